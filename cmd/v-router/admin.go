@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"net/http/pprof"
+	"reflect"
+	"strconv"
+)
+
+const redactedValue = "<redacted>"
+
+func isAdminListenerEnabled() bool {
+	return getConfig().AdminListenPort != "" || getConfig().AdminListenSocket != ""
+}
+
+// Address of the admin listener for messages, e.g. '127.0.0.1:8081' or 'unix:/run/v-router/admin.sock'
+func getAdminListenAddress() string {
+	if getConfig().AdminListenSocket != "" {
+		return "unix:" + getConfig().AdminListenSocket
+	}
+	return fmt.Sprintf("%s:%s", getConfig().AdminListenAddress, getConfig().AdminListenPort)
+}
+
+// Router for the admin listener: status, probes, metrics and debug endpoints
+func newAdminRouter() *mux.Router {
+	r := mux.NewRouter()
+
+	r.Path("/health").HandlerFunc(healthCheckHandler).Name("health")
+	r.Path("/ready").HandlerFunc(readinessHandler).Name("ready")
+
+	// Probes are not protected with authentication
+	protected := r.PathPrefix("/").Subrouter()
+	protected.Path("/metrics").HandlerFunc(metricsHandler).Name("metrics")
+	protected.Path("/config").HandlerFunc(configHandler).Name("config")
+	protected.Path("/debug/pprof/cmdline").HandlerFunc(pprof.Cmdline)
+	protected.Path("/debug/pprof/profile").HandlerFunc(pprof.Profile)
+	protected.Path("/debug/pprof/symbol").HandlerFunc(pprof.Symbol)
+	protected.Path("/debug/pprof/trace").HandlerFunc(pprof.Trace)
+	protected.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index).Name("pprof")
+	if isProductsSupervisor() {
+		// Endpoints of the channels data are served by the admin listeners of the product processes
+		protected.Path("/status").HandlerFunc(productsStatusHandler).Name("status")
+		protected.PathPrefix("/products/{product}/").HandlerFunc(productAdminProxyHandler).Name("productAdmin")
+	} else {
+		protected.Path("/status").HandlerFunc(statusHandler).Name("status")
+		protected.Path("/debug/resolve").HandlerFunc(debugResolveHandler).Name("debugResolve")
+		protected.Path("/history").HandlerFunc(historyHandler).Name("history")
+		protected.Path("/history/{id:[0-9]+}").HandlerFunc(historyEntryHandler).Name("historyEntry")
+		protected.Path("/admin/groups/{group}/channels/{channel}").Methods("PUT").HandlerFunc(adminChannelHandler).Name("adminChannel")
+		protected.Path("/admin/history/{id:[0-9]+}/rollback").Methods("POST").HandlerFunc(adminRollbackHandler).Name("adminRollback")
+	}
+	protected.Use(basicAuthMiddleware)
+
+	r.Use(LoggingMiddleware)
+
+	return r
+}
+
+// Check the basic auth credentials if the admin user is configured
+func basicAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if getConfig().AdminUser != "" {
+			user, password, ok := r.BasicAuth()
+			if !ok ||
+				subtle.ConstantTimeCompare([]byte(user), []byte(getConfig().AdminUser)) != 1 ||
+				subtle.ConstantTimeCompare([]byte(password), []byte(getConfig().AdminPassword)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="v-router"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Metrics in the Prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	// Refresh the readiness gauge
+	_ = getReadiness()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w)
+}
+
+// Dump the configuration with the secret values redacted
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(getRedactedConfig(*getConfig()))
+}
+
+// Get a copy of the configuration, where non-empty fields tagged with `secret:"true"` are redacted
+func getRedactedConfig(config GlobalConfigType) GlobalConfigType {
+	value := reflect.ValueOf(&config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.Tag.Get("secret") != "true" || field.Type.Kind() != reflect.String {
+			continue
+		}
+		if value.Field(i).String() != "" {
+			value.Field(i).SetString(redactedValue)
+		}
+	}
+	return config
+}
+
+type adminChannelRequestType struct {
+	Version string `json:"version"`
+}
+
+// Assign version to the group channel. Responds with the new status.
+// E.g. PUT /admin/groups/v1/channels/stable with {"version": "v1.2.3+fix6"}
+func adminChannelHandler(w http.ResponseWriter, r *http.Request) {
+	var request adminChannelRequestType
+
+	if getConfig().AdminUser == "" {
+		http.Error(w, "Admin API is disabled, set the admin user and password to enable it", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("Can't decode request (%s)", err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err := validateChannelAssignment(vars["group"], vars["channel"], request.Version); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := updateChannelVersion(vars["group"], vars["channel"], request.Version); err != nil {
+		log.Errorln(fmt.Sprintf("Admin API: can't set version %s for group %s, channel %s (%s)", request.Version, vars["group"], vars["channel"], err.Error()))
+		status := http.StatusUnprocessableEntity
+		if _, ok := err.(*scheduledChannelError); ok {
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	log.Infoln(fmt.Sprintf("Admin API: version %s is set for group %s, channel %s", request.Version, vars["group"], vars["channel"]))
+
+	statusHandler(w, r)
+}
+
+// Restore the channels file from the history snapshot. Responds with the new status.
+// E.g. POST /admin/history/12/rollback
+func adminRollbackHandler(w http.ResponseWriter, r *http.Request) {
+	if getConfig().AdminUser == "" {
+		http.Error(w, "Admin API is disabled, set the admin user and password to enable it", http.StatusForbidden)
+		return
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if _, err := getHistoryEntry(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := rollbackHistory(id); err != nil {
+		log.Errorln(fmt.Sprintf("Admin API: can't roll back to the history snapshot %d (%s)", id, err.Error()))
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	log.Infoln(fmt.Sprintf("Admin API: rolled back to the history snapshot %d", id))
+
+	statusHandler(w, r)
+}
+
+// Check that the group and the channel are known and the version is valid
+func validateChannelAssignment(group, channel, version string) error {
+	if !isKnownChannel(channel) {
+		return fmt.Errorf("unknown channel %s", channel)
+	}
+	if _, err := parseVersion(version); err != nil {
+		return err
+	}
+	for _, item := range getReleasesStatus().Groups {
+		if item.Name == group {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown group %s", group)
+}
+
+// Check whether the channel name is one of the supported channels
+func isKnownChannel(channel string) bool {
+	if channel == "latest" {
+		return getConfig().UseLatestChannel
+	}
+	for _, item := range getConfig().Channels {
+		if item == channel {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdminRouter(t *testing.T) {
+	defer changeTestConfig(func(config *GlobalConfigType) {
+		config.AdminUser = "admin"
+		config.AdminPassword = "secret"
+	})()
+	r := newAdminRouter()
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest("GET", "/status", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Status should be 401 without credentials, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest("GET", "/health", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Health probe should not require credentials, got %d", recorder.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/config", nil)
+	req.SetBasicAuth("admin", "secret")
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	var config GlobalConfigType
+	if err := json.NewDecoder(recorder.Body).Decode(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.AdminPassword != redactedValue {
+		t.Errorf("Admin password is not redacted, got %s", config.AdminPassword)
+	}
+
+	req = httptest.NewRequest("GET", "/metrics", nil)
+	req.SetBasicAuth("admin", "secret")
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	if !strings.Contains(recorder.Body.String(), `vrouter_http_requests_total{route="config",code="200"} 1`) {
+		t.Errorf("Request metrics are missing:\n%s", recorder.Body.String())
+	}
+	if !strings.Contains(recorder.Body.String(), `vrouter_http_request_duration_seconds_count{route="config"} 1`) {
+		t.Errorf("Request duration metrics are missing:\n%s", recorder.Body.String())
+	}
+}
+
+func TestAdminChannelHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "v-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data, err := ioutil.ReadFile(getConfig().PathChannelsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	channelsFile := filepath.Join(dir, "channels.yaml")
+	if err := ioutil.WriteFile(channelsFile, append([]byte("# Release channels\n"), data...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	restoreConfig := changeTestConfig(func(config *GlobalConfigType) {
+		config.PathChannelsFile = channelsFile
+		config.AdminUser = "admin"
+		config.AdminPassword = "secret"
+	})
+	defer func() {
+		restoreConfig()
+		_ = updateReleasesStatus()
+	}()
+	_ = updateReleasesStatus()
+	r := newAdminRouter()
+
+	req := httptest.NewRequest("PUT", "/admin/groups/v1/channels/stable", strings.NewReader(`{"version": "v1.2.4"}`))
+	req.SetBasicAuth("admin", "secret")
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Status should be 200, got %d (%s)", recorder.Code, recorder.Body.String())
+	}
+
+	if version, _ := getVersionFromChannelAndGroup(getReleasesStatus(), "stable", "v1"); version != "v1.2.4" {
+		t.Errorf("Version is not switched, expected v1.2.4, got %s", version)
+	}
+	data, _ = ioutil.ReadFile(channelsFile)
+	if !strings.Contains(string(data), "# Release channels") || !strings.Contains(string(data), "version: v1.2.4") {
+		t.Errorf("Channels file is not updated properly:\n%s", string(data))
+	}
+
+	req = httptest.NewRequest("PUT", "/admin/groups/v1/channels/unknown", strings.NewReader(`{"version": "v1.2.4"}`))
+	req.SetBasicAuth("admin", "secret")
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Status should be 400 for unknown channel, got %d", recorder.Code)
+	}
+
+	// The channel has no active entry
+	data = []byte("groups:\n - name: v1\n   channels:\n    - name: stable\n      version: v1.2.3\n    - name: rock-solid\n      version: v1.2.3\n      activateAt: 2030-01-01T00:00:00Z\n")
+	if err := ioutil.WriteFile(channelsFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest("PUT", "/admin/groups/v1/channels/rock-solid", strings.NewReader(`{"version": "v1.2.4"}`))
+	req.SetBasicAuth("admin", "secret")
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusConflict {
+		t.Errorf("Status should be 409 for a channel without an active entry, got %d", recorder.Code)
+	}
+}
+
+func TestSetChannelVersionJSON(t *testing.T) {
+	data := []byte(`{"groups": [{"name": "v1", "channels": [{"version": "v1.2.3", "name": "stable", "eolDate": "2021-01-01", "x-team": "docs"}]}], "comment": "Release channels"}`)
+
+	data, err := setChannelVersion(data, "channels.json", "v1", "stable", "v1.2.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+  "groups": [
+    {
+      "name": "v1",
+      "channels": [
+        {
+          "version": "v1.2.4",
+          "name": "stable",
+          "x-team": "docs"
+        }
+      ]
+    }
+  ],
+  "comment": "Release channels"
+}`
+	if string(data) != expected {
+		t.Errorf("Channels file is not updated properly, expected:\n%s\ngot:\n%s", expected, string(data))
+	}
+}
+
+func TestSetChannelVersionScheduled(t *testing.T) {
+	// The scheduled entry is listed before the active one
+	data := []byte(`groups:
+ - name: v1
+   channels:
+    - name: stable
+      version: v1.2.3+fix10
+      activateAt: 2030-01-01T00:00:00Z
+    - name: stable
+      version: v1.2.3+fix6
+      canary:
+       - version: v1.2.3+fix10
+         weight: 10
+`)
+	result, err := setChannelVersion(data, "channels.yaml", "v1", "stable", "v1.3.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `groups:
+  - name: v1
+    channels:
+      - name: stable
+        version: v1.2.3+fix10
+        activateAt: 2030-01-01T00:00:00Z
+      - name: stable
+        version: v1.3.0
+`
+	if string(result) != expected {
+		t.Errorf("Channels file is not updated properly, expected:\n%s\ngot:\n%s", expected, string(result))
+	}
+
+	jsonData := []byte(`{"groups": [{"name": "v1", "channels": [{"name": "stable", "version": "v1.2.4", "schedule": []}, {"name": "stable", "version": "v1.2.3"}]}]}`)
+	if result, err = setChannelVersion(jsonData, "channels.json", "v1", "stable", "v1.3.0"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(result), `"version": "v1.2.4"`) || !strings.Contains(string(result), `"version": "v1.3.0"`) {
+		t.Errorf("Scheduled entry is changed %s", result)
+	}
+
+	// There is no active entry to change
+	data = []byte("groups:\n - name: v1\n   channels:\n    - name: stable\n      version: v1.2.3\n      activateAt: 2030-01-01T00:00:00Z\n")
+	if _, err := setChannelVersion(data, "channels.yaml", "v1", "stable", "v1.3.0"); err == nil {
+		t.Errorf("Channel with only scheduled entries should not be changed")
+	} else if _, ok := err.(*scheduledChannelError); !ok {
+		t.Errorf("Wrong error %v", err)
+	}
+}
+
+func TestChannelsDiffScheduled(t *testing.T) {
+	previous, err := decodeReleasesStatus([]byte(`groups:
+ - name: v1
+   channels:
+    - name: stable
+      version: v1.2.3
+      activateAt: 2100-01-01T10:00:00Z
+    - name: stable
+      version: v1.2.2
+`), "channels.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := decodeReleasesStatus([]byte(`groups:
+ - name: v1
+   channels:
+    - name: stable
+      version: v1.2.4
+      activateAt: 2100-01-01T10:00:00Z
+    - name: stable
+      version: v1.2.2
+`), "channels.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff := getChannelsDiff(&previous, &current)
+	if len(diff) != 1 || diff[0].ActivateAt == nil || diff[0].OldVersion != "v1.2.3" || diff[0].NewVersion != "v1.2.4" {
+		t.Errorf("Wrong diff of the scheduled entry: %+v", diff)
+	}
+}
+
+func TestHistoryRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "v-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data, err := ioutil.ReadFile(getConfig().PathChannelsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The history is recorded when the published data changes
+	channelsFile := filepath.Join(dir, "channels.yaml")
+	if err := ioutil.WriteFile(channelsFile, append([]byte("# Release channels\n"), data...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	restoreConfig := changeTestConfig(func(config *GlobalConfigType) {
+		config.PathChannelsFile = channelsFile
+		config.PathHistoryFile = filepath.Join(dir, "history.json")
+	})
+	defer func() {
+		restoreConfig()
+		history.Entries = nil
+		_ = updateReleasesStatus()
+	}()
+
+	if err := updateReleasesStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateChannelVersion("v1", "stable", "v1.2.4"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := getHistory()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 history entries, got %d", len(entries))
+	}
+	expected := channelChangeType{Group: "v1", Channel: "stable", OldVersion: "v1.2.3+fix6", NewVersion: "v1.2.4"}
+	if entries[0].Source != historySourceAdminAPI || len(entries[0].Diff) != 1 || entries[0].Diff[0] != expected {
+		t.Errorf("Wrong history entry: %+v", entries[0])
+	}
+
+	if err := rollbackHistory(entries[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := getVersionFromChannelAndGroup(getReleasesStatus(), "stable", "v1"); version != "v1.2.3+fix6" {
+		t.Errorf("Version is not rolled back, expected v1.2.3+fix6, got %s", version)
+	}
+
+	// The history is persisted
+	history.Entries = nil
+	if err := loadHistory(); err != nil {
+		t.Fatal(err)
+	}
+	if entries = getHistory(); len(entries) != 3 || entries[0].Source != "rollback:1" {
+		t.Errorf("Wrong history loaded: %+v", entries)
+	}
+}
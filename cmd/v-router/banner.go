@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	bannerReasonEOL        = "eol"
+	bannerReasonDeprecated = "deprecated"
+	bannerReasonOutdated   = "outdated"
+)
+
+// Data of the banner template
+type bannerDataType struct {
+	Reason        string // 'eol', 'deprecated' or 'outdated' (older than the default channel of the group)
+	Version       string
+	EOLDate       string
+	Lang          string
+	StableVersion string
+	StableURL     string // The same page in the stable version, or the root of the stable version if there is no such page
+}
+
+var bodyTagRegexp = regexp.MustCompile(`(?i)<body[^>]*>`)
+
+// Get the page path from the original URI, for templates
+func getOriginalPagePath(r *http.Request) string {
+	originalURI, err := url.Parse(r.Header.Get("x-original-uri"))
+	if err != nil {
+		return ""
+	}
+	return originalURI.Path
+}
+
+// Get the page path from the request URI, for pages served by v-router
+func getRequestPagePath(r *http.Request) string {
+	return r.URL.Path
+}
+
+// Get the banner data for the page of the version, if the version is EOL, deprecated or outdated
+func getBannerData(r *http.Request, pagePath string) (*bannerDataType, bool) {
+	langPrefix, lang, version, pageURLRelative, ok := parseVersionPagePath(pagePath)
+	if !ok {
+		return nil, false
+	}
+	if lang == "" {
+		lang = getDomainLang(r)
+	}
+
+	versionItem, err := parseVersion(version)
+	if err != nil {
+		return nil, false
+	}
+	// The banner links to the stable version of the page's group, or of the default group if the group has no stable version
+	stableVersion, ok := getGroupDefaultChannelVersion(versionItem)
+	if !ok {
+		if stableVersion, err = getVersionFromChannelAndGroup(getReleasesStatus(), getConfig().DefaultChannel, getConfig().DefaultGroup); err != nil {
+			return nil, false
+		}
+	}
+	if strings.TrimPrefix(stableVersion, "v") == strings.TrimPrefix(version, "v") {
+		return nil, false
+	}
+
+	result := &bannerDataType{Version: version, Lang: lang, StableVersion: stableVersion}
+	metadata := getVersionMetadata(getReleasesStatus(), version)
+	switch {
+	case isVersionEOL(metadata, time.Now()):
+		result.Reason = bannerReasonEOL
+		result.EOLDate = metadata.EOLDate
+	case metadata.Deprecated:
+		result.Reason = bannerReasonDeprecated
+	case isVersionOutdated(versionItem):
+		result.Reason = bannerReasonOutdated
+	default:
+		return nil, false
+	}
+
+	result.StableURL = getVersionPageURL(langPrefix, lang, stableVersion, pageURLRelative)
+	return result, true
+}
+
+// Check whether the version is older than the version of the default channel of its group
+func isVersionOutdated(version versionType) bool {
+	groupVersion, ok := getGroupDefaultChannelVersion(version)
+	if !ok {
+		return false
+	}
+	defaultVersion, err := parseVersion(groupVersion)
+	return err == nil && compareVersions(version, defaultVersion) < 0
+}
+
+// Get the version of the default channel of the version's group
+func getGroupDefaultChannelVersion(version versionType) (string, bool) {
+	for _, group := range getReleasesStatus().Groups {
+		if !isVersionInGroup(version, group.Name) {
+			continue
+		}
+		for _, channel := range group.Channels {
+			if channel.Name != getConfig().DefaultChannel {
+				continue
+			}
+			if _, err := parseVersion(channel.Version); err == nil {
+				return channel.Version, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Render the localized banner template: <PathStatic>/<lang><PathTpls>/<BannerTemplate>, or
+// <PathStatic><PathTpls>/<BannerTemplate> if there is no localized one
+func renderBanner(data *bannerDataType) (template.HTML, error) {
+	filename := fmt.Sprintf("%s/%s%s/%s", getRootFilesPath(), data.Lang, getConfig().PathTpls, getConfig().BannerTemplate)
+	if _, err := os.Stat(filename); err != nil {
+		filename = fmt.Sprintf("%s%s/%s", getRootFilesPath(), getConfig().PathTpls, getConfig().BannerTemplate)
+	}
+
+	tpl, err := template.ParseFiles(filename)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
+
+// Get the banner for the page, or an empty string if the page needs no banner or banners are disabled
+func getBanner(r *http.Request, pagePath string) template.HTML {
+	if getConfig().BannerTemplate == "" {
+		return ""
+	}
+	data, ok := getBannerData(r, pagePath)
+	if !ok {
+		return ""
+	}
+	banner, err := renderBanner(data)
+	if err != nil {
+		log.Errorln(fmt.Sprintf("Can't render banner template %s (%s)", getConfig().BannerTemplate, err.Error()))
+		return ""
+	}
+	return banner
+}
+
+// Exact version URLs are routed to the banner and retention handlers only if they are enabled.
+// It's checked on every request, as both settings are reloadable.
+func isVersionRouteEnabled(r *http.Request, _ *mux.RouteMatch) bool {
+	return getConfig().BannerTemplate != "" || isRetentionEnabled()
+}
+
+// Whether the page can be an HTML page, by the extension of its path
+func isHTMLPagePath(pagePath string) bool {
+	switch strings.ToLower(path.Ext(pagePath)) {
+	case "", ".html", ".htm":
+		return true
+	}
+	return false
+}
+
+// Response writer, which buffers a successful HTML response to insert the banner, and passes other responses through
+type bannerResponseWriter struct {
+	http.ResponseWriter
+	buffering   bool
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *bannerResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code == http.StatusOK && strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		w.buffering = true
+		w.Header().Del("Content-Length")
+		w.Header().Del("Last-Modified")
+		w.Header().Del("ETag")
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *bannerResponseWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buffering {
+		return w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Insert the banner after the <body> tag of HTML pages of EOL, deprecated and outdated versions.
+// Pages which need no banner and other files are served as is, only HTML pages with a banner are buffered.
+func bannerMiddleware(next http.Handler, getPagePath func(r *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pagePath := getPagePath(r)
+		if getConfig().BannerTemplate == "" || !isHTMLPagePath(pagePath) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		banner := getBanner(r, pagePath)
+		if banner == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Get the whole page, the response is changed
+		req := r.Clone(r.Context())
+		for _, header := range []string{"Range", "If-Range", "If-Modified-Since", "If-None-Match"} {
+			req.Header.Del(header)
+		}
+		bw := &bannerResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(bw, req)
+		if !bw.buffering {
+			return
+		}
+
+		body := bw.body.Bytes()
+		if !bytes.Contains(body, []byte(banner)) {
+			if loc := bodyTagRegexp.FindIndex(body); loc != nil {
+				body = append(body[:loc[1]:loc[1]], append([]byte(banner), body[loc[1]:]...)...)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(body)
+	})
+}
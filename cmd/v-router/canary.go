@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// Clients are split to buckets, canary versions get buckets by their weights in percents
+	canaryBuckets = 100
+
+	canaryCookieMaxAge = 365 * 24 * 60 * 60
+
+	canaryVariantMain   = "main"
+	canaryVariantCanary = "canary"
+)
+
+// Version served to a part of the clients of the channel
+type CanaryVersionType struct {
+	Version string `json:"version"`
+	Weight  int    `json:"weight"` // Percent of the clients
+}
+
+func init() {
+	registerMetric("vrouter_canary_requests_total", "counter", "Total number of requests to channels with canary versions by group, channel, version and variant.")
+}
+
+// Get the IP of the client, from the X-Real-IP header behind nginx
+func getClientIP(r *http.Request) string {
+	if ip := r.Header.Get("x-real-ip"); ip != "" {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// Get the bucket of the client: from the cookie, so the client stays on the chosen versions,
+// or from the hash of the client IP, if there is no cookie yet
+func getCanaryBucket(r *http.Request) int {
+	if getConfig().CanaryCookie != "" {
+		if cookie, err := r.Cookie(getConfig().CanaryCookie); err == nil {
+			if bucket, err := strconv.Atoi(cookie.Value); err == nil && bucket >= 0 && bucket < canaryBuckets {
+				return bucket
+			}
+		}
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(getClientIP(r)))
+	return int(hash.Sum32() % canaryBuckets)
+}
+
+// Save the bucket of the client to the cookie, if the cookie isn't set yet
+func setCanaryCookie(w http.ResponseWriter, r *http.Request) {
+	if getConfig().CanaryCookie == "" || !hasCanaryVersions(getReleasesStatus()) {
+		return
+	}
+	if _, err := r.Cookie(getConfig().CanaryCookie); err == nil {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     getConfig().CanaryCookie,
+		Value:    strconv.Itoa(getCanaryBucket(r)),
+		Path:     "/",
+		MaxAge:   canaryCookieMaxAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Responses depend on the bucket of the client if there are canary versions, so they must not be shared
+// by caches: the bucket is kept in the cookie, or is chosen by the client IP before the cookie is set
+func setCanaryVary(w http.ResponseWriter) {
+	if !hasCanaryVersions(getReleasesStatus()) {
+		return
+	}
+	w.Header().Set("Cache-Control", "private")
+	if getConfig().CanaryCookie == "" {
+		return
+	}
+	for _, value := range w.Header()["Vary"] {
+		if value == "Cookie" {
+			return
+		}
+	}
+	w.Header().Add("Vary", "Cookie")
+}
+
+// Choose the version of the channel for the bucket. Canary versions get the first buckets by their weights,
+// the version of the channel gets the rest.
+func getCanaryVersion(channel ChannelType, bucket int) (version, variant string) {
+	limit := 0
+	for _, item := range channel.Canary {
+		limit += item.Weight
+		if bucket < limit {
+			return item.Version, canaryVariantCanary
+		}
+	}
+	return channel.Version, canaryVariantMain
+}
+
+func hasCanaryVersions(releases *ReleasesStatusType) bool {
+	for _, group := range releases.Groups {
+		for _, channel := range group.Channels {
+			if len(channel.Canary) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Get the channels data for the request: versions of channels with canary versions are chosen for the client,
+// so group URLs and menus are consistent
+func getRequestReleases(r *http.Request) *ReleasesStatusType {
+	releases := getReleasesStatus()
+	if !hasCanaryVersions(releases) {
+		return releases
+	}
+
+	bucket := getCanaryBucket(r)
+	result := &ReleasesStatusType{Upcoming: releases.Upcoming}
+	for _, group := range releases.Groups {
+		channels := make([]ChannelType, len(group.Channels))
+		copy(channels, group.Channels)
+		for i, channel := range channels {
+			if version, variant := getCanaryVersion(channel, bucket); variant == canaryVariantCanary {
+				channels[i].Version = version
+				// Metadata of the channel entry belongs to the version of the channel
+				channels[i].VersionMetadataType = VersionMetadataType{}
+			}
+		}
+		group.Channels = channels
+		result.Groups = append(result.Groups, group)
+	}
+	return result
+}
+
+// Count the request to the version of the group, if the version is chosen from canary versions of a channel.
+// Canary versions are logged with the request, see LoggingMiddleware.
+func observeCanaryVersion(r *http.Request, group, version string) {
+	for _, item := range getReleasesStatus().Groups {
+		if item.Name != group {
+			continue
+		}
+		for _, channel := range item.Channels {
+			if len(channel.Canary) == 0 {
+				continue
+			}
+			variant := ""
+			if channel.Version == version {
+				variant = canaryVariantMain
+			}
+			for _, canary := range channel.Canary {
+				if canary.Version == version {
+					variant = canaryVariantCanary
+				}
+			}
+			if variant == "" {
+				continue
+			}
+			addMetric("vrouter_canary_requests_total", 1, "group", group, "channel", channel.Name, "version", version, "variant", variant)
+			if variant == canaryVariantCanary {
+				getLogData(r).Canary = version
+			}
+			return
+		}
+	}
+}
+
+// Check canary versions of the channel entry or the schedule item: versions, and weights from 1 to 99 with the sum below 100
+func lintCanary(filename, group string, node *yaml.Node) (issues []lintIssueType) {
+	addIssue := func(severity string, node *yaml.Node, format string, args ...interface{}) {
+		issues = append(issues, newLintIssue(severity, filename, node, fmt.Sprintf(format, args...)))
+	}
+
+	canaryNode := getYAMLMappingValue(node, "canary")
+	if canaryNode == nil {
+		return
+	}
+	if canaryNode.Kind != yaml.SequenceNode {
+		addIssue(lintSeverityError, canaryNode, "canary should be a list of versions with weights")
+		return
+	}
+
+	total := 0
+	for _, item := range canaryNode.Content {
+		versionNode := getYAMLMappingValue(item, "version")
+		weightNode := getYAMLMappingValue(item, "weight")
+		if versionNode == nil || weightNode == nil {
+			addIssue(lintSeverityError, item, "canary should have version and weight")
+			continue
+		}
+		if versionItem, err := parseVersion(versionNode.Value); err != nil {
+			addIssue(lintSeverityError, versionNode, "can't parse version %s", versionNode.Value)
+		} else if !isVersionInGroup(versionItem, group) {
+			addIssue(lintSeverityWarning, versionNode, "version %s doesn't belong to group %s", versionNode.Value, group)
+		}
+		weight, err := strconv.Atoi(weightNode.Value)
+		if err != nil || weight < 1 || weight >= canaryBuckets {
+			addIssue(lintSeverityError, weightNode, "canary weight %s should be from 1 to %d", weightNode.Value, canaryBuckets-1)
+			continue
+		}
+		total += weight
+	}
+	if total >= canaryBuckets {
+		addIssue(lintSeverityError, canaryNode, "total canary weight %d should be less than %d", total, canaryBuckets)
+	}
+	return
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// Channel overrides are enabled if the channels which can be requested are configured
+func isChannelOverrideEnabled() bool {
+	return len(getConfig().ChannelOverrideChannels) > 0
+}
+
+func isChannelOverrideAllowed(channel string) bool {
+	for _, item := range getConfig().ChannelOverrideChannels {
+		if item == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the channel requested for this request only: from the query parameter, the header or the cookie, in this order.
+// Only allowed channels are accepted. The query parameter is taken from the request URI, or from the original URI
+// for templates (see getDocPageURLRelative).
+func getChannelOverride(r *http.Request, useURI bool) string {
+	if !isChannelOverrideEnabled() {
+		return ""
+	}
+
+	var query url.Values
+	if useURI {
+		query = r.URL.Query()
+	} else if originalURI, err := url.Parse(r.Header.Get("x-original-uri")); err == nil {
+		query = originalURI.Query()
+	}
+
+	var candidates []string
+	if getConfig().ChannelOverrideQueryParam != "" {
+		candidates = append(candidates, query.Get(getConfig().ChannelOverrideQueryParam))
+	}
+	if getConfig().ChannelOverrideHeader != "" {
+		candidates = append(candidates, r.Header.Get(getConfig().ChannelOverrideHeader))
+	}
+	if getConfig().ChannelOverrideCookie != "" {
+		if cookie, err := r.Cookie(getConfig().ChannelOverrideCookie); err == nil {
+			candidates = append(candidates, cookie.Value)
+		}
+	}
+	for _, channel := range candidates {
+		if isChannelOverrideAllowed(channel) {
+			return channel
+		}
+	}
+	return ""
+}
+
+// Responses depend on the header and the cookie of the channel override
+func setChannelOverrideVary(w http.ResponseWriter) {
+	if !isChannelOverrideEnabled() {
+		return
+	}
+	if getConfig().ChannelOverrideHeader != "" {
+		w.Header().Add("Vary", getConfig().ChannelOverrideHeader)
+	}
+	if getConfig().ChannelOverrideCookie != "" {
+		w.Header().Add("Vary", "Cookie")
+	}
+}
+
+// Make the channel of the query parameter sticky: set the cookie to the allowed channel,
+// or remove the cookie if the parameter is empty
+func setChannelOverrideCookie(w http.ResponseWriter, r *http.Request) {
+	if !isChannelOverrideEnabled() || getConfig().ChannelOverrideQueryParam == "" || getConfig().ChannelOverrideCookie == "" {
+		return
+	}
+	query := r.URL.Query()
+	if _, ok := query[getConfig().ChannelOverrideQueryParam]; !ok {
+		return
+	}
+
+	cookie := &http.Cookie{Name: getConfig().ChannelOverrideCookie, Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode}
+	switch channel := query.Get(getConfig().ChannelOverrideQueryParam); {
+	case channel == "":
+		cookie.MaxAge = -1
+	case isChannelOverrideAllowed(channel):
+		cookie.Value = channel
+	default:
+		return
+	}
+	http.SetCookie(w, cookie)
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Serializes changes of the channels file. Readers take the read lock,
+// so the file isn't read in the middle of a change.
+var channelsFileMutex sync.RWMutex
+
+// Keys of the version metadata in channel entries
+var versionMetadataKeys = []string{"releaseDate", "releaseNotesURL", "eolDate", "deprecated", "securityAdvisory", "gitRef"}
+
+// Keys of channel entries, which belong to the version of the entry and are removed when the version changes:
+// the metadata and canary versions rolled out on top of the previous version
+var channelVersionKeys = append(append([]string{}, versionMetadataKeys...), "canary")
+
+// Keys of scheduled channel entries, such entries are not changed by the admin API
+var scheduledChannelKeys = []string{"activateAt", "schedule"}
+
+// The channel has only scheduled entries, so there is no active entry to change
+type scheduledChannelError struct {
+	Group, Channel string
+}
+
+func (e *scheduledChannelError) Error() string {
+	return fmt.Sprintf("channel %s of group %s has only scheduled entries, change them in the channels file", e.Channel, e.Group)
+}
+
+// Set version for the group channel in the channels file content, keeping the original format.
+// The active entry of the channel is changed, scheduled entries (with 'activateAt' or 'schedule') are skipped.
+// The channel is added if the group has no such channel. Metadata and canary versions of the previous version are removed.
+func setChannelVersion(data []byte, filename, group, channel, version string) ([]byte, error) {
+	if strings.HasSuffix(filename, ".json") {
+		return setChannelVersionJSON(data, group, channel, version)
+	} else if strings.HasSuffix(filename, ".yaml") || strings.HasSuffix(filename, ".yml") {
+		return setChannelVersionYAML(data, group, channel, version)
+	}
+	return nil, fmt.Errorf("unknown format of channels file %s", filename)
+}
+
+// Edit YAML document in place to keep comments and the order of keys
+func setChannelVersionYAML(data []byte, group, channel, version string) ([]byte, error) {
+	var doc yaml.Node
+
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("channels file is not a mapping")
+	}
+
+	groups := getYAMLMappingValue(doc.Content[0], "groups")
+	if groups == nil || groups.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("channels file has no groups")
+	}
+
+	for _, groupNode := range groups.Content {
+		if name := getYAMLMappingValue(groupNode, "name"); name == nil || name.Value != group {
+			continue
+		}
+		channels := getYAMLMappingValue(groupNode, "channels")
+		if channels == nil {
+			channels = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			groupNode.Content = append(groupNode.Content, newYAMLString("channels"), channels)
+		}
+		scheduled := false
+		for _, channelNode := range channels.Content {
+			if name := getYAMLMappingValue(channelNode, "name"); name == nil || name.Value != channel {
+				continue
+			}
+			if hasYAMLMappingKeys(channelNode, scheduledChannelKeys) {
+				scheduled = true
+				continue
+			}
+			if versionNode := getYAMLMappingValue(channelNode, "version"); versionNode != nil {
+				if versionNode.Value != version {
+					deleteYAMLMappingKeys(channelNode, channelVersionKeys)
+				}
+				versionNode.Kind = yaml.ScalarNode
+				versionNode.Tag = "!!str"
+				versionNode.Value = version
+			} else {
+				channelNode.Content = append(channelNode.Content, newYAMLString("version"), newYAMLString(version))
+			}
+			return encodeYAML(&doc)
+		}
+		if scheduled {
+			return nil, &scheduledChannelError{Group: group, Channel: channel}
+		}
+		channels.Content = append(channels.Content, &yaml.Node{
+			Kind:    yaml.MappingNode,
+			Tag:     "!!map",
+			Content: []*yaml.Node{newYAMLString("name"), newYAMLString(channel), newYAMLString("version"), newYAMLString(version)},
+		})
+		return encodeYAML(&doc)
+	}
+	return nil, fmt.Errorf("no group %s in channels file", group)
+}
+
+// Get value node for the key of the mapping node, or nil
+func getYAMLMappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// Whether the mapping node has any of the keys
+func hasYAMLMappingKeys(node *yaml.Node, keys []string) bool {
+	for _, key := range keys {
+		if getYAMLMappingValue(node, key) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete the keys and their values from the mapping node
+func deleteYAMLMappingKeys(node *yaml.Node, keys []string) {
+	var content []*yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		deleted := false
+		for _, key := range keys {
+			if node.Content[i].Value == key {
+				deleted = true
+			}
+		}
+		if !deleted {
+			content = append(content, node.Content[i], node.Content[i+1])
+		}
+	}
+	node.Content = content
+}
+
+func newYAMLString(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+func encodeYAML(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Edit JSON document, keeping fields unknown to ReleasesStatusType and the order of keys
+func setChannelVersionJSON(data []byte, group, channel, version string) ([]byte, error) {
+	var doc jsonObject
+	var groups []jsonObject
+
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if err := doc.getValue("groups", &groups); err != nil || groups == nil {
+		return nil, fmt.Errorf("channels file has no groups")
+	}
+
+	for i, groupItem := range groups {
+		var name string
+		if err := groupItem.getValue("name", &name); err != nil || name != group {
+			continue
+		}
+		var channels []jsonObject
+		err := groupItem.getValue("channels", &channels)
+		if err != nil {
+			return nil, err
+		}
+
+		found, scheduled := false, false
+		for j, channelItem := range channels {
+			var name, oldVersion string
+			if err := channelItem.getValue("name", &name); err != nil || name != channel {
+				continue
+			}
+			if channelItem.hasKeys(scheduledChannelKeys) {
+				scheduled = true
+				continue
+			}
+			if err := channelItem.getValue("version", &oldVersion); err != nil || oldVersion != version {
+				channelItem = channelItem.deleteKeys(channelVersionKeys)
+			}
+			if channels[j], err = channelItem.setValue("version", version); err != nil {
+				return nil, err
+			}
+			found = true
+			break
+		}
+		if !found && scheduled {
+			return nil, &scheduledChannelError{Group: group, Channel: channel}
+		}
+		if !found {
+			channels = append(channels, jsonObject{{"name", mustMarshalJSON(channel)}, {"version", mustMarshalJSON(version)}})
+		}
+
+		if groups[i], err = groupItem.setValue("channels", channels); err != nil {
+			return nil, err
+		}
+		if doc, err = doc.setValue("groups", groups); err != nil {
+			return nil, err
+		}
+		return json.MarshalIndent(doc, "", "  ")
+	}
+	return nil, fmt.Errorf("no group %s in channels file", group)
+}
+
+// JSON object keeping the order of keys
+type jsonObject []jsonField
+
+type jsonField struct {
+	Key   string
+	Value json.RawMessage
+}
+
+func (o *jsonObject) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return fmt.Errorf("JSON object expected")
+	}
+	*o = jsonObject{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := token.(string)
+		if !ok {
+			return fmt.Errorf("JSON object key expected")
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+		*o = append(*o, jsonField{Key: key, Value: value})
+	}
+	return nil
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(mustMarshalJSON(field.Key))
+		buf.WriteByte(':')
+		buf.Write(field.Value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Decode the value of the key, the result is left untouched if there is no such key
+func (o jsonObject) getValue(key string, result interface{}) error {
+	for _, field := range o {
+		if field.Key == key {
+			return json.Unmarshal(field.Value, result)
+		}
+	}
+	return nil
+}
+
+// Set the value of the key in place, or add the key to the end
+func (o jsonObject) setValue(key string, value interface{}) (jsonObject, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	for i, field := range o {
+		if field.Key == key {
+			o[i].Value = data
+			return o, nil
+		}
+	}
+	return append(o, jsonField{Key: key, Value: data}), nil
+}
+
+func (o jsonObject) hasKeys(keys []string) bool {
+	for _, field := range o {
+		for _, key := range keys {
+			if field.Key == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (o jsonObject) deleteKeys(keys []string) (result jsonObject) {
+	for _, field := range o {
+		deleted := false
+		for _, key := range keys {
+			if field.Key == key {
+				deleted = true
+			}
+		}
+		if !deleted {
+			result = append(result, field)
+		}
+	}
+	return
+}
+
+// Marshal a value which can't fail, e.g. a string
+func mustMarshalJSON(value interface{}) []byte {
+	data, _ := json.Marshal(value)
+	return data
+}
+
+// Write the file atomically: write a temporary file in the same directory and rename it
+func writeFileAtomic(filename string, data []byte) error {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(data); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpFile.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), filename)
+}
+
+// Check the new content of the channels file, save it and switch to it.
+// The source of the change is recorded in the history.
+func replaceChannelsFile(data []byte, source string) error {
+	releases, err := loadReleasesStatus(data, getConfig().PathChannelsFile)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(getConfig().PathChannelsFile, data); err != nil {
+		return err
+	}
+	publishReleasesStatus(releases, data, source)
+	setReleasesLoadState(nil)
+	return nil
+}
+
+// Assign the version to the group channel and persist the change
+func updateChannelVersion(group, channel, version string) error {
+	if getChannelsSourceType() != historySourceFile {
+		return fmt.Errorf("channels source %s is read-only", getConfig().PathChannelsFile)
+	}
+	if isChannelsSignatureRequired() {
+		return fmt.Errorf("channels file %s is signed, it can't be changed without a new signature", getConfig().PathChannelsFile)
+	}
+
+	channelsFileMutex.Lock()
+	defer channelsFileMutex.Unlock()
+
+	data, err := ioutil.ReadFile(getConfig().PathChannelsFile)
+	if err != nil {
+		return err
+	}
+	data, err = setChannelVersion(data, getConfig().PathChannelsFile, group, channel, version)
+	if err != nil {
+		return err
+	}
+	return replaceChannelsFile(data, historySourceAdminAPI)
+}
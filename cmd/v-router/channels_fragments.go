@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Name of the channels data merged from fragments, to choose the format
+const mergedChannelsFileName = "merged-channels.yaml"
+
+// Fragment of the channels data, when the channels file is a directory or a glob
+type channelsFragmentType struct {
+	Filename string
+	Data     []byte
+}
+
+// Fragments of the last merged channels data, to lint them with their own positions.
+// State identifies the files the data is merged from, to merge them again only if they change.
+var channelsFragments = struct {
+	sync.Mutex
+	Location  string
+	State     string
+	Data      []byte
+	Fragments []channelsFragmentType
+}{}
+
+// Whether the existing paths of the channels file are directories, to not check it on every request
+var channelsFragmentsDirs sync.Map
+
+// The channels file is a directory or a glob of YAML and JSON fragments
+func isChannelsFragmentsSource(location string) bool {
+	if isHTTPChannelsSource(location) {
+		return false
+	}
+	if strings.ContainsAny(location, "*?[") {
+		return true
+	}
+	if isDir, ok := channelsFragmentsDirs.Load(location); ok {
+		return isDir.(bool)
+	}
+	fi, err := os.Stat(location)
+	if err != nil {
+		return false
+	}
+	channelsFragmentsDirs.Store(location, fi.IsDir())
+	return fi.IsDir()
+}
+
+func isChannelsFragmentFile(filename string) bool {
+	if strings.HasPrefix(filepath.Base(filename), ".") {
+		return false
+	}
+	switch filepath.Ext(filename) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// Get files of the fragments: YAML and JSON files of the directory or files matching the glob, sorted by path.
+// Hidden files (e.g. temporary files of editors) are skipped.
+func getChannelsFragmentFiles(location string) ([]string, error) {
+	files, _, err := listChannelsFragmentFiles(location)
+	return files, err
+}
+
+// Get files of the fragments and their state: the size and the modification time of the files
+// and of their signatures, if the signatures are required
+func listChannelsFragmentFiles(location string) (files []string, state string, err error) {
+	var matches []string
+	if fi, err := os.Stat(location); err == nil && fi.IsDir() {
+		entries, err := ioutil.ReadDir(location)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, entry := range entries {
+			matches = append(matches, filepath.Join(location, entry.Name()))
+		}
+	} else if matches, err = filepath.Glob(location); err != nil {
+		return nil, "", err
+	}
+
+	sort.Strings(matches)
+	var stateItems []string
+	for _, filename := range matches {
+		if fi, err := os.Stat(filename); err == nil && !fi.IsDir() && isChannelsFragmentFile(filename) {
+			files = append(files, filename)
+			stateItems = append(stateItems, getFileState(filename, fi))
+			if isChannelsSignatureRequired() {
+				fi, _ = os.Stat(getSignatureLocation(filename))
+				stateItems = append(stateItems, getFileState(getSignatureLocation(filename), fi))
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, "", fmt.Errorf("no channels fragments found in %s", location)
+	}
+	return files, strings.Join(stateItems, "\n"), nil
+}
+
+func getFileState(filename string, fi os.FileInfo) string {
+	if fi == nil {
+		return filename + " -"
+	}
+	return fmt.Sprintf("%s %d %d", filename, fi.Size(), fi.ModTime().UnixNano())
+}
+
+func readChannelsFragments(location string) ([]channelsFragmentType, error) {
+	files, err := getChannelsFragmentFiles(location)
+	if err != nil {
+		return nil, err
+	}
+	return readChannelsFragmentFiles(files)
+}
+
+func readChannelsFragmentFiles(files []string) ([]channelsFragmentType, error) {
+	var fragments []channelsFragmentType
+	for _, filename := range files {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		fragments = append(fragments, channelsFragmentType{Filename: filename, Data: data})
+	}
+	return fragments, nil
+}
+
+// Merge groups of the fragments in the order of the fragments to one YAML document.
+// If a group is defined in several fragments, the first definition is used (the duplicates are lint errors).
+func mergeChannelsFragments(fragments []channelsFragmentType) ([]byte, error) {
+	groups := &yaml.Node{Kind: yaml.SequenceNode}
+	groupNames := make(map[string]bool)
+
+	for _, fragment := range fragments {
+		var doc yaml.Node
+		if err := yaml.Unmarshal(fragment.Data, &doc); err != nil {
+			return nil, fmt.Errorf("can't decode channels fragment %s (%s)", fragment.Filename, err.Error())
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		if doc.Content[0].Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("channels fragment %s is not a mapping", fragment.Filename)
+		}
+		fragmentGroups := getYAMLMappingValue(doc.Content[0], "groups")
+		if fragmentGroups == nil || fragmentGroups.Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, groupNode := range fragmentGroups.Content {
+			if nameNode := getYAMLMappingValue(groupNode, "name"); nameNode != nil {
+				if groupNames[nameNode.Value] {
+					continue
+				}
+				groupNames[nameNode.Value] = true
+			}
+			groups.Content = append(groups.Content, groupNode)
+		}
+	}
+
+	root := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{{Kind: yaml.ScalarNode, Value: "groups"}, groups}}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Read the fragments and merge them. The last merged data is returned if the files haven't changed.
+func readChannelsFragmentsData(location string) ([]byte, error) {
+	files, state, err := listChannelsFragmentFiles(location)
+	if err != nil {
+		return nil, err
+	}
+	channelsFragments.Lock()
+	if channelsFragments.Location == location && channelsFragments.State == state && channelsFragments.Data != nil {
+		data := channelsFragments.Data
+		channelsFragments.Unlock()
+		return data, nil
+	}
+	channelsFragments.Unlock()
+
+	fragments, err := readChannelsFragmentFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	if isChannelsSignatureRequired() {
+		// Every fragment is signed by its owner
+		for _, fragment := range fragments {
+			if err = verifyFileSignature(fragment.Filename, fragment.Data); err != nil {
+				break
+			}
+		}
+		setChannelsSignatureStatus(err)
+		if err != nil {
+			return nil, err
+		}
+	}
+	data, err := mergeChannelsFragments(fragments)
+	if err != nil {
+		return nil, err
+	}
+
+	channelsFragments.Lock()
+	defer channelsFragments.Unlock()
+	channelsFragments.Location = location
+	channelsFragments.State = state
+	channelsFragments.Data = data
+	channelsFragments.Fragments = fragments
+	return data, nil
+}
+
+// Merge the fragments again on the next read, e.g. when the signature keys change
+func resetChannelsFragmentsCache() {
+	channelsFragments.Lock()
+	defer channelsFragments.Unlock()
+	channelsFragments.State = ""
+}
+
+// Get the fragments of the merged channels data, or the data itself if it isn't merged
+func getChannelsFragments(data []byte, filename string) []channelsFragmentType {
+	channelsFragments.Lock()
+	defer channelsFragments.Unlock()
+	if filename == mergedChannelsFileName && bytes.Equal(channelsFragments.Data, data) {
+		return channelsFragments.Fragments
+	}
+	return []channelsFragmentType{{Filename: filename, Data: data}}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChannelsFragments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "v-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"b.json":     `{"groups": [{"name": "v2", "channels": [{"name": "stable", "version": "v2.0.1"}]}]}`,
+		"a.yaml":     "groups:\n - name: v1\n   channels:\n    - name: stable\n      version: v1.2.3\n",
+		"c.yaml":     "groups:\n - name: v2\n   channels:\n    - name: stable\n      version: v2.0.2\n",
+		".tmp.yaml":  "broken: [",
+		"readme.txt": "not a fragment",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer changeTestConfig(func(config *GlobalConfigType) { config.DefaultGroup = "v1" })()
+
+	for _, location := range []string{dir, filepath.Join(dir, "*")} {
+		defer changeTestConfig(func(config *GlobalConfigType) { config.PathChannelsFile = location })()
+		if getChannelsSourceType() != historySourceFragments || checkChannelsSource(getConfig()) != nil {
+			t.Fatalf("%s: wrong source type %s", location, getChannelsSourceType())
+		}
+
+		data, err := readChannelsData()
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases, err := decodeReleasesStatus(data, getChannelsFileName(location))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Groups are merged in the order of the files, the first definition of a group is used
+		if len(releases.Groups) != 2 || releases.Groups[0].Name != "v1" || releases.Groups[1].Channels[0].Version != "v2.0.1" {
+			t.Errorf("%s: wrong merged data %+v", location, releases.Groups)
+		}
+
+		result, err := lintOnLoad(data, getChannelsFileName(location))
+		if err != nil {
+			t.Fatal(err)
+		}
+		issues := result.Issues
+		expected := filepath.Join(dir, "c.yaml") + ":2:10: error: group v2 is already defined in " + filepath.Join(dir, "b.json") + ":1:22"
+		if len(issues) != 1 || issues[0].String() != expected {
+			t.Errorf("%s: wrong issues %v", location, issues)
+		}
+	}
+
+	// The fragments are merged again only when they change
+	defer changeTestConfig(func(config *GlobalConfigType) { config.PathChannelsFile = dir })()
+	data, _ := readChannelsData()
+	if cached, _ := readChannelsData(); &cached[0] != &data[0] {
+		t.Errorf("Unchanged fragments should not be merged again")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.yaml"), []byte(strings.Replace(files["a.yaml"], "v1.2.3", "v1.2.4", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ = readChannelsData(); !strings.Contains(string(data), "v1.2.4") {
+		t.Errorf("Changed fragments should be merged again")
+	}
+
+	defer changeTestConfig(func(config *GlobalConfigType) { config.PathChannelsFile = filepath.Join(dir, "*.yml") })()
+	if err := checkChannelsSource(getConfig()); err == nil {
+		t.Errorf("A glob without fragments should be reported")
+	}
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Maximum size of the channels data fetched over HTTP
+const maxRemoteChannelsSize = 10 << 20
+
+// Status of the channels source
+type channelsSourceStatusType struct {
+	Type         string                       `json:"type"`
+	Location     string                       `json:"location"`
+	Repository   string                       `json:"repository,omitempty"`
+	Fragments    []string                     `json:"fragments,omitempty"`
+	Signature    *channelsSignatureStatusType `json:"signature,omitempty"`
+	Status       string                       `json:"status"`
+	Msg          string                       `json:"msg,omitempty"`
+	ETag         string                       `json:"etag,omitempty"`
+	LastModified string                       `json:"lastModified,omitempty"`
+	Commit       string                       `json:"commit,omitempty"`
+	Author       string                       `json:"author,omitempty"`
+	FetchedAt    *time.Time                   `json:"fetchedAt,omitempty"`
+	CheckedAt    *time.Time                   `json:"checkedAt,omitempty"`
+	Failures     int                          `json:"failures,omitempty"`
+}
+
+// Result of a fetch from the remote channels source
+type remoteChannelsFetchType struct {
+	Data         []byte // nil if the data is not modified
+	ETag         string
+	LastModified string
+	Commit       string
+	Author       string
+}
+
+// Interval of checks of the channels source for changes
+const channelsCheckInterval = time.Second
+
+// State of the channels source at the last load of the channels data, see getChannelsSourceState
+var channelsSourceState = struct {
+	sync.Mutex
+	State string
+}{}
+
+// The last good snapshot of the channels data fetched over HTTP or from a git repository and the state of polling.
+// The snapshot is dropped when the location or the public keys it is verified with change.
+var remoteChannels = struct {
+	sync.Mutex
+	Location     string
+	PublicKeys   string
+	Data         []byte
+	ETag         string
+	LastModified string
+	Commit       string
+	Author       string
+	FetchedAt    time.Time
+	CheckedAt    time.Time
+	LastError    error
+	Failures     int
+}{}
+
+func init() {
+	registerMetric("vrouter_channels_source_fetches_total", "counter", "Total number of channels data fetches from the remote source by result.")
+}
+
+func isHTTPChannelsSource(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// The channels data is fetched over HTTP or read from a git repository, and is polled for changes
+func isRemoteChannelsSource() bool {
+	return getConfig().ChannelsGitDir != "" || isHTTPChannelsSource(getConfig().PathChannelsFile)
+}
+
+// Get the type of the channels source, it is also the source of history snapshots
+func getChannelsSourceType() string {
+	if getConfig().ChannelsGitDir != "" {
+		return historySourceGit
+	}
+	if isHTTPChannelsSource(getConfig().PathChannelsFile) {
+		return historySourceHTTP
+	}
+	if isChannelsFragmentsSource(getConfig().PathChannelsFile) {
+		return historySourceFragments
+	}
+	return historySourceFile
+}
+
+// Get the location of the channels data, which identifies the snapshot of the remote source
+func getChannelsSourceLocation() string {
+	if getConfig().ChannelsGitDir != "" {
+		return getConfig().ChannelsGitDir + "#" + getConfig().PathChannelsFile
+	}
+	return getConfig().PathChannelsFile
+}
+
+// Get the file name of the channels data to detect the format, e.g. 'channels.yaml' for https://example.com/channels.yaml?v=1
+// or for origin/main:docs/channels.yaml in a git repository. Fragments are merged to YAML.
+func getChannelsFileName(location string) string {
+	if getConfig().ChannelsGitDir != "" {
+		_, filename := splitGitChannelsLocation(location)
+		return path.Base(filename)
+	}
+	if isChannelsFragmentsSource(location) {
+		return mergedChannelsFileName
+	}
+	if isHTTPChannelsSource(location) {
+		if sourceURL, err := url.Parse(location); err == nil {
+			return path.Base(sourceURL.Path)
+		}
+	}
+	return location
+}
+
+// Split the location of the channels file in a git repository to the ref and the path, e.g.
+// 'origin/main:channels.yaml'. The ref is HEAD if it is omitted.
+func splitGitChannelsLocation(location string) (ref, filename string) {
+	if i := strings.Index(location, ":"); i >= 0 {
+		return location[:i], location[i+1:]
+	}
+	return "HEAD", location
+}
+
+// Check that the channels source is accessible, or is a valid URL
+func checkChannelsSource(config *GlobalConfigType) error {
+	if config.ChannelsGitDir != "" {
+		ref, filename := splitGitChannelsLocation(config.PathChannelsFile)
+		_, err := runGit(config.ChannelsGitDir, config.ChannelsFetchTimeout, "cat-file", "-e", ref+":"+filename)
+		return err
+	}
+	if isHTTPChannelsSource(config.PathChannelsFile) {
+		_, err := url.Parse(config.PathChannelsFile)
+		return err
+	}
+	if isChannelsFragmentsSource(config.PathChannelsFile) {
+		_, err := getChannelsFragmentFiles(config.PathChannelsFile)
+		return err
+	}
+	_, err := os.Stat(config.PathChannelsFile)
+	return err
+}
+
+// Read the channels data from the configured source
+func readChannelsData() ([]byte, error) {
+	if isRemoteChannelsSource() {
+		return getRemoteChannelsData()
+	}
+	if isChannelsFragmentsSource(getConfig().PathChannelsFile) {
+		return readChannelsFragmentsData(getConfig().PathChannelsFile)
+	}
+
+	data, err := ioutil.ReadFile(getConfig().PathChannelsFile)
+	if err == nil && isChannelsSignatureRequired() {
+		err = verifyFileSignature(getConfig().PathChannelsFile, data)
+		setChannelsSignatureStatus(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Get the last good snapshot of the remote channels data. It is fetched on the first call, e.g. by commands.
+func getRemoteChannelsData() ([]byte, error) {
+	remoteChannels.Lock()
+	fetched := remoteChannels.Location == getChannelsSourceLocation() && remoteChannels.PublicKeys == getChannelsPublicKeysID() &&
+		!remoteChannels.CheckedAt.IsZero()
+	remoteChannels.Unlock()
+	if !fetched {
+		fetchRemoteChannels()
+	}
+
+	remoteChannels.Lock()
+	defer remoteChannels.Unlock()
+	if remoteChannels.Data == nil {
+		if remoteChannels.LastError != nil {
+			return nil, remoteChannels.LastError
+		}
+		return nil, fmt.Errorf("channels data is not fetched yet from %s", getConfig().PathChannelsFile)
+	}
+	return remoteChannels.Data, nil
+}
+
+// Get the commit and the author of the channels data, if it is the current snapshot of the git repository
+func getRemoteChannelsRevision(data []byte) (commit, author string) {
+	remoteChannels.Lock()
+	defer remoteChannels.Unlock()
+	if getConfig().ChannelsGitDir == "" || !bytes.Equal(remoteChannels.Data, data) {
+		return "", ""
+	}
+	return remoteChannels.Commit, remoteChannels.Author
+}
+
+// Fetch the channels data, if it is changed. The previous snapshot is kept if the fetch fails
+// or the data can't be decoded.
+func fetchRemoteChannels() {
+	location := getChannelsSourceLocation()
+	publicKeys := getChannelsPublicKeysID()
+
+	remoteChannels.Lock()
+	if remoteChannels.Location != location || remoteChannels.PublicKeys != publicKeys {
+		if remoteChannels.Location == location && remoteChannels.Data != nil {
+			log.Infoln(fmt.Sprintf("Channels public keys are changed, verifying channels data from %s again", location))
+		}
+		remoteChannels.Location = location
+		remoteChannels.PublicKeys = publicKeys
+		remoteChannels.Data = nil
+		remoteChannels.ETag = ""
+		remoteChannels.LastModified = ""
+		remoteChannels.Commit = ""
+		remoteChannels.Author = ""
+		remoteChannels.FetchedAt = time.Time{}
+		remoteChannels.Failures = 0
+	}
+	last := remoteChannelsFetchType{}
+	if remoteChannels.Data != nil {
+		last = remoteChannelsFetchType{ETag: remoteChannels.ETag, LastModified: remoteChannels.LastModified, Commit: remoteChannels.Commit}
+	}
+	remoteChannels.Unlock()
+
+	var fetch *remoteChannelsFetchType
+	var err error
+	if getConfig().ChannelsGitDir != "" {
+		fetch, err = doFetchGitChannels(getConfig().ChannelsGitDir, getConfig().PathChannelsFile, &last)
+	} else {
+		fetch, err = doFetchRemoteChannels(getConfig().PathChannelsFile, &last)
+	}
+
+	remoteChannels.Lock()
+	defer remoteChannels.Unlock()
+	if remoteChannels.Location != location || remoteChannels.PublicKeys != publicKeys {
+		// The source or the keys are changed during the fetch
+		return
+	}
+	remoteChannels.CheckedAt = time.Now()
+
+	result := "updated"
+	switch {
+	case err != nil:
+		result = "error"
+		remoteChannels.LastError = err
+		remoteChannels.Failures++
+		log.Errorln(fmt.Sprintf("Can't fetch channels data from %s (%s), keeping the previous data", location, err.Error()))
+	case fetch.Data == nil:
+		result = "not_modified"
+	default:
+		if !bytes.Equal(fetch.Data, remoteChannels.Data) {
+			log.Infoln(fmt.Sprintf("Channels data is fetched from %s (%d bytes)", location, len(fetch.Data)))
+		}
+		remoteChannels.Data = fetch.Data
+		remoteChannels.ETag = fetch.ETag
+		remoteChannels.LastModified = fetch.LastModified
+		remoteChannels.Commit = fetch.Commit
+		remoteChannels.Author = fetch.Author
+		remoteChannels.FetchedAt = remoteChannels.CheckedAt
+	}
+	if err == nil {
+		remoteChannels.LastError = nil
+		remoteChannels.Failures = 0
+	}
+	if isChannelsSignatureRequired() && (err != nil || fetch.Data != nil) {
+		setChannelsSignatureStatus(err)
+	}
+	addMetric("vrouter_channels_source_fetches_total", 1, "result", result)
+}
+
+// Fetch the channels data over HTTP. Returns nil data if the data is not modified since the last fetch.
+func doFetchRemoteChannels(location string, last *remoteChannelsFetchType) (*remoteChannelsFetchType, error) {
+	req, err := http.NewRequest("GET", location, nil)
+	if err != nil {
+		return nil, err
+	}
+	if last.ETag != "" {
+		req.Header.Set("If-None-Match", last.ETag)
+	}
+	if last.LastModified != "" {
+		req.Header.Set("If-Modified-Since", last.LastModified)
+	}
+
+	client := &http.Client{Timeout: getConfig().ChannelsFetchTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && last.ETag+last.LastModified != "" {
+		return &remoteChannelsFetchType{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxRemoteChannelsSize))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decodeReleasesStatus(data, getChannelsFileName(location)); err != nil {
+		return nil, fmt.Errorf("can't decode channels data (%s)", err.Error())
+	}
+	if isChannelsSignatureRequired() {
+		signature, err := doFetchRemoteSignature(client, getSignatureLocation(location))
+		if err != nil {
+			return nil, err
+		}
+		if err := verifyChannelsSignature(location, data, signature); err != nil {
+			return nil, err
+		}
+	}
+	return &remoteChannelsFetchType{Data: data, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}, nil
+}
+
+// Fetch the detached signature of the channels data. Returns nil if there is no signature.
+func doFetchRemoteSignature(client *http.Client, location string) ([]byte, error) {
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %s of signature %s", resp.Status, location)
+	}
+	return ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, 1024))
+}
+
+// Read the channels data at the ref of the git repository. Returns nil data if the ref still points
+// to the commit of the last read.
+func doFetchGitChannels(dir, location string, last *remoteChannelsFetchType) (*remoteChannelsFetchType, error) {
+	ref, filename := splitGitChannelsLocation(location)
+	timeout := getConfig().ChannelsFetchTimeout
+
+	output, err := runGit(dir, timeout, "rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return nil, err
+	}
+	commit := strings.TrimSpace(string(output))
+	if commit == last.Commit {
+		return &remoteChannelsFetchType{}, nil
+	}
+
+	output, err = runGit(dir, timeout, "log", "-1", "--format=%an <%ae>", commit)
+	if err != nil {
+		return nil, err
+	}
+	author := strings.TrimSpace(string(output))
+
+	data, err := runGit(dir, timeout, "cat-file", "blob", commit+":"+filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decodeReleasesStatus(data, path.Base(filename)); err != nil {
+		return nil, fmt.Errorf("can't decode channels data at %s (%s)", commit, err.Error())
+	}
+	if isChannelsSignatureRequired() {
+		// The signature is committed with the file
+		signature, err := runGit(dir, timeout, "cat-file", "blob", commit+":"+filename+signatureSuffix)
+		if err != nil {
+			signature = nil
+		}
+		if err := verifyChannelsSignature(commit+":"+filename, data, signature); err != nil {
+			return nil, err
+		}
+	}
+	return &remoteChannelsFetchType{Data: data, Commit: commit, Author: author}, nil
+}
+
+// Run a git command in the repository and get its output
+func runGit(dir string, timeout time.Duration, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %s", args[0], err.Error())
+	}
+	return output, nil
+}
+
+// Get the delay before the next fetch. After failures fetches are retried sooner,
+// starting from 1s and doubling up to the poll interval.
+func getRemoteChannelsDelay() time.Duration {
+	remoteChannels.Lock()
+	defer remoteChannels.Unlock()
+
+	interval := getConfig().ChannelsPollInterval
+	if interval < time.Second {
+		interval = time.Second
+	}
+	if remoteChannels.Failures == 0 || remoteChannels.Failures > 30 {
+		return interval
+	}
+	if delay := time.Second << (remoteChannels.Failures - 1); delay < interval {
+		return delay
+	}
+	return interval
+}
+
+// Load the channels data in the background, requests only read the published data. The source is checked
+// every channelsCheckInterval (the remote source is fetched every ChannelsPollInterval), and the data is loaded
+// again when the source changes or a scheduled assignment is due.
+func runChannelsSourcePoller() {
+	var nextFetch time.Time
+	for {
+		if isRemoteChannelsSource() && !time.Now().Before(nextFetch) {
+			fetchRemoteChannels()
+			nextFetch = time.Now().Add(getRemoteChannelsDelay())
+		}
+		if isChannelsReloadNeeded() {
+			_ = updateReleasesStatus()
+		}
+		time.Sleep(channelsCheckInterval)
+	}
+}
+
+// Get the state of the channels source, which changes when the channels data may change: the size
+// and the modification time of the channels file (the fragments, the signatures), or the fetched snapshot
+func getChannelsSourceState() string {
+	location := getChannelsSourceLocation()
+	if isRemoteChannelsSource() {
+		remoteChannels.Lock()
+		defer remoteChannels.Unlock()
+		return fmt.Sprintf("%s %s %s %v", location, remoteChannels.PublicKeys, remoteChannels.FetchedAt, remoteChannels.Data != nil)
+	}
+	if isChannelsFragmentsSource(location) {
+		_, state, err := listChannelsFragmentFiles(location)
+		if err != nil {
+			return location + " " + err.Error()
+		}
+		return state
+	}
+	fi, _ := os.Stat(location)
+	state := getFileState(location, fi)
+	if isChannelsSignatureRequired() {
+		fi, _ = os.Stat(getSignatureLocation(location))
+		state += "\n" + getFileState(getSignatureLocation(location), fi)
+	}
+	return state
+}
+
+// Whether the channels data should be loaded again: the source is changed since the last load,
+// or the first of the upcoming assignments is due
+func isChannelsReloadNeeded() bool {
+	channelsSourceState.Lock()
+	state := channelsSourceState.State
+	channelsSourceState.Unlock()
+	if state != getChannelsSourceState() {
+		return true
+	}
+	upcoming := getReleasesStatus().Upcoming
+	return len(upcoming) > 0 && !upcoming[0].ActivateAt.After(time.Now())
+}
+
+func getChannelsSourceStatus() *channelsSourceStatusType {
+	result := &channelsSourceStatusType{
+		Type:      getChannelsSourceType(),
+		Location:  getConfig().PathChannelsFile,
+		Status:    "ok",
+		Signature: getChannelsSignatureStatus(),
+	}
+
+	if !isRemoteChannelsSource() {
+		if err := checkChannelsSource(getConfig()); err != nil {
+			result.Status = "error"
+			result.Msg = err.Error()
+		}
+		if isChannelsFragmentsSource(getConfig().PathChannelsFile) {
+			result.Fragments, _ = getChannelsFragmentFiles(getConfig().PathChannelsFile)
+		}
+		return result
+	}
+
+	remoteChannels.Lock()
+	defer remoteChannels.Unlock()
+	if remoteChannels.LastError != nil {
+		result.Status = "error"
+		result.Msg = remoteChannels.LastError.Error()
+	}
+	result.ETag = remoteChannels.ETag
+	result.LastModified = remoteChannels.LastModified
+	result.Repository = getConfig().ChannelsGitDir
+	result.Commit = remoteChannels.Commit
+	result.Author = remoteChannels.Author
+	result.Failures = remoteChannels.Failures
+	if !remoteChannels.FetchedAt.IsZero() {
+		fetchedAt := remoteChannels.FetchedAt.UTC()
+		result.FetchedAt = &fetchedAt
+	}
+	if !remoteChannels.CheckedAt.IsZero() {
+		checkedAt := remoteChannels.CheckedAt.UTC()
+		result.CheckedAt = &checkedAt
+	}
+	return result
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRemoteChannelsSource(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/channels.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var failing, notModified int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"1"`)
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	restoreConfig := changeTestConfig(func(config *GlobalConfigType) {
+		config.PathChannelsFile = server.URL + "/channels.yaml?v=1"
+		config.ChannelsPollInterval = 30 * time.Second
+	})
+	defer func() {
+		restoreConfig()
+		setChannelsSignatureStatus(nil)
+		remoteChannels.Lock()
+		remoteChannels.Location = ""
+		remoteChannels.Unlock()
+	}()
+
+	if getChannelsFileName(getConfig().PathChannelsFile) != "channels.yaml" {
+		t.Errorf("Wrong file name %s", getChannelsFileName(getConfig().PathChannelsFile))
+	}
+
+	// The first read fetches the data
+	result, err := readChannelsData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != string(data) {
+		t.Errorf("Wrong channels data %s", result)
+	}
+
+	fetchRemoteChannels()
+	if atomic.LoadInt32(&notModified) != 1 {
+		t.Errorf("The data should be requested with the ETag")
+	}
+
+	// The last good data is kept on failures, and fetches are retried sooner
+	atomic.StoreInt32(&failing, 1)
+	fetchRemoteChannels()
+	fetchRemoteChannels()
+	if result, err := readChannelsData(); err != nil || string(result) != string(data) {
+		t.Errorf("The last good data should be kept on failures")
+	}
+	status := getChannelsSourceStatus()
+	if status.Status != "error" || status.Failures != 2 || status.ETag != `"1"` {
+		t.Errorf("Wrong source status %+v", status)
+	}
+	if delay := getRemoteChannelsDelay(); delay != 2*time.Second {
+		t.Errorf("Wrong delay after failures %s", delay)
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	fetchRemoteChannels()
+	if status := getChannelsSourceStatus(); status.Status != "ok" || status.Failures != 0 {
+		t.Errorf("Wrong source status after recovery %+v", status)
+	}
+	if delay := getRemoteChannelsDelay(); delay != 30*time.Second {
+		t.Errorf("Wrong delay %s", delay)
+	}
+
+	// The data fetched without the keys is verified again, when the keys are added
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer changeTestConfig(func(config *GlobalConfigType) {
+		config.ChannelsPublicKeys = []string{base64.StdEncoding.EncodeToString(publicKey)}
+	})()
+	if _, err := readChannelsData(); err == nil {
+		t.Errorf("Unsigned data should not be served after the keys are added")
+	}
+}
+
+func TestGitChannelsSource(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "v-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=Jane Doe", "-c", "user.email=jane@example.com"}, args...)...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, output)
+		}
+	}
+	commit := func(version string) {
+		content := "groups:\n - name: v1\n   channels:\n    - name: stable\n      version: " + version + "\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, "channels.yaml"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", "channels.yaml")
+		git("commit", "-q", "-m", "Promote "+version)
+	}
+	git("init", "-q")
+	commit("v1.2.3")
+
+	restoreConfig := changeTestConfig(func(config *GlobalConfigType) {
+		config.ChannelsGitDir = dir
+		config.PathChannelsFile = "HEAD:channels.yaml"
+	})
+	defer func() {
+		restoreConfig()
+		remoteChannels.Lock()
+		remoteChannels.Location = ""
+		remoteChannels.Unlock()
+	}()
+
+	if err := checkChannelsSource(getConfig()); err != nil {
+		t.Fatal(err)
+	}
+	if getChannelsSourceType() != historySourceGit || getChannelsFileName(getConfig().PathChannelsFile) != "channels.yaml" {
+		t.Errorf("Wrong source type %s or file name %s", getChannelsSourceType(), getChannelsFileName(getConfig().PathChannelsFile))
+	}
+
+	data, err := readChannelsData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := getChannelsSourceStatus()
+	if status.Status != "ok" || len(status.Commit) != 40 || status.Author != "Jane Doe <jane@example.com>" {
+		t.Errorf("Wrong source status %+v", status)
+	}
+	if commit, author := getRemoteChannelsRevision(data); commit != status.Commit || author != status.Author {
+		t.Errorf("Wrong revision %s by %s", commit, author)
+	}
+
+	// The data is read again when the ref moves
+	commit("v1.2.4")
+	fetchRemoteChannels()
+	data, err = readChannelsData()
+	if err != nil || !strings.Contains(string(data), "v1.2.4") {
+		t.Errorf("The data should be read again when the ref moves, got %s", data)
+	}
+	if getChannelsSourceStatus().Commit == status.Commit {
+		t.Errorf("The commit should change")
+	}
+
+	defer changeTestConfig(func(config *GlobalConfigType) { config.PathChannelsFile = "HEAD:missing.yaml" })()
+	if err := checkChannelsSource(getConfig()); err == nil {
+		t.Errorf("A missing file should be reported")
+	}
+}
+
+func TestChannelsReloadNeeded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "v-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "channels.yaml")
+	data := "groups:\n - name: v1\n   channels:\n    - name: stable\n      version: v1.2.3\n"
+	if err := ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	restoreConfig := changeTestConfig(func(config *GlobalConfigType) { config.PathChannelsFile = filename })
+	defer func() {
+		restoreConfig()
+		_ = updateReleasesStatus()
+	}()
+
+	if !isChannelsReloadNeeded() {
+		t.Errorf("A new channels file should be loaded")
+	}
+	if err := updateReleasesStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if isChannelsReloadNeeded() {
+		t.Errorf("The loaded channels file should not be loaded again")
+	}
+
+	// A scheduled assignment is due
+	data += "    - name: stable\n      version: v1.2.4\n      activateAt: " + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + "\n"
+	if err := ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !isChannelsReloadNeeded() {
+		t.Errorf("The changed channels file should be loaded")
+	}
+	if err := updateReleasesStatus(); err != nil {
+		t.Fatal(err)
+	}
+	releases := *getReleasesStatus()
+	releases.Upcoming = []upcomingChangeType{{Group: "v1", Channel: "stable", Version: "v1.2.4", ActivateAt: time.Now().Add(-time.Second)}}
+	publishReleasesStatus(releases, []byte(data+"\n"), historySourceFile)
+	if !isChannelsReloadNeeded() {
+		t.Errorf("The channels file should be loaded when a scheduled assignment is due")
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+type commandType struct {
+	Name  string
+	Usage string
+	Run   func(args []string) int
+}
+
+var commands []commandType
+
+func init() {
+	commands = []commandType{
+		{"serve", "serve", serveCommand},
+		{"validate", "validate", validateCommand},
+		{"resolve", "resolve <url>", resolveCommand},
+		{"routes", "routes", routesCommand},
+		{"lint", "lint [channels file...]", lintCommand},
+	}
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	for _, item := range commands {
+		fmt.Fprintf(os.Stderr, "  v-router %s\n", item.Usage)
+	}
+}
+
+// Load the channels data for a command. Commands don't change the state of the server:
+// the history isn't recorded, and target URLs aren't validated.
+func loadCommandReleasesStatus() error {
+	config := *getConfig()
+	config.PathHistoryFile = ""
+	config.UrlValidation = false
+	setConfig(config)
+	return updateReleasesStatus()
+}
+
+// The 'validate' command. Checks the channels file, templates and the static files directory.
+func validateCommand(args []string) int {
+	exitCode := 0
+
+	// Every product is validated by a separate process, the same way as it is served
+	if isProductsSupervisor() {
+		executable, err := os.Executable()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		for _, product := range getConfig().Products {
+			fmt.Printf("Product %s:\n", product.Name)
+			cmd := exec.Command(executable, "validate")
+			cmd.Env = append(os.Environ(), "VROUTER_PRODUCT="+product.Name)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			if err := cmd.Run(); err != nil {
+				exitCode = 1
+			}
+		}
+		return exitCode
+	}
+
+	_ = loadCommandReleasesStatus()
+
+	printCheck := func(name string, err error) {
+		if err != nil {
+			fmt.Printf("%-14s error: %s\n", name, err.Error())
+			exitCode = 1
+		} else {
+			fmt.Printf("%-14s ok\n", name)
+		}
+	}
+	for _, item := range readinessChecks {
+		if item.Name == "shutdown" {
+			continue
+		}
+		printCheck(item.Name, item.Check())
+	}
+	printCheck("consistency", checkConsistency())
+
+	if data, err := readChannelsData(); err == nil {
+		if issues, err := lintChannelsFile(data, getConfig().PathChannelsFile); err == nil {
+			for _, issue := range issues {
+				fmt.Println(issue.String())
+			}
+			if hasLintErrors(issues) {
+				exitCode = 1
+			}
+		}
+	}
+	return exitCode
+}
+
+// The 'resolve' command. Prints which handler matches the URL and where the request is redirected.
+func resolveCommand(args []string) int {
+	if len(args) != 1 {
+		printUsage()
+		return 2
+	}
+
+	requestURL, err := url.Parse(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	host := requestURL.Host
+	if host == "" {
+		host = "localhost"
+	}
+
+	if err := loadCommandReleasesStatus(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	result, err := resolveRequest(newRouter(), requestURL.RequestURI(), host)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	var vars []string
+	for key, value := range result.Vars {
+		vars = append(vars, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(vars)
+
+	fmt.Printf("Route:             %s (%s)\n", result.Route, result.PathTemplate)
+	fmt.Printf("Vars:              %s\n", strings.Join(vars, " "))
+	fmt.Printf("Version:           %s\n", result.Version)
+	fmt.Printf("Page URL:          %s\n", result.PageURLRelative)
+	if result.Validation != "" {
+		fmt.Printf("Validation:        %s\n", result.Validation)
+	}
+	if result.Status != 0 {
+		fmt.Printf("Status:            %d %s\n", result.Status, http.StatusText(result.Status))
+	}
+	if result.Location != "" {
+		fmt.Printf("Redirect:          %s\n", result.Location)
+	}
+	if result.AccelRedirect != "" {
+		fmt.Printf("X-Accel-Redirect:  %s\n", result.AccelRedirect)
+	}
+	return 0
+}
+
+// The 'routes' command. Prints routes of the public router and of the admin router, if enabled.
+func routesCommand(args []string) int {
+	printRoutes := func(router *mux.Router) {
+		_ = router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+			pathTemplate, err := route.GetPathTemplate()
+			if err != nil {
+				return nil
+			}
+			methods, _ := route.GetMethods()
+			name := route.GetName()
+			if name == "" {
+				name = "-"
+			}
+			fmt.Printf("%-20s %-8s %s\n", name, strings.Join(methods, ","), pathTemplate)
+			return nil
+		})
+	}
+
+	printRoutes(newRouter())
+	if isAdminListenerEnabled() {
+		fmt.Printf("\nAdmin listener (%s):\n", getAdminListenAddress())
+		printRoutes(newAdminRouter())
+	}
+	return 0
+}
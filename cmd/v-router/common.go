@@ -1,127 +1,253 @@
 package main
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
-	"io/ioutil"
+	"html/template"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"reflect"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type GlobalConfigType struct {
-    DefaultGroup     string `default:"v1" split_words:"true"`
-    DefaultChannel   string `default:"stable" split_words:"true"`
-    UseLatestChannel bool   `default:"false" split_words:"true"`
-    ListenAddress    string `default:"0.0.0.0" split_words:"true"`
-    ListenPort       string `default:"8080" split_words:"true"`
-    LogLevel         string `default:"warn" split_words:"true"`
-    LogFormat        string `default:"text" split_words:"true"`
-    PathChannelsFile string `default:"channels.yaml" split_words:"true"`
-    PathStatic       string `default:"root" split_words:"true"`
-    PathTpls         string `default:"/includes" split_words:"true"`
-    LocationVersions string `default:"/documentation" split_words:"true"`
-    I18nType         string `default:"domain" split_words:"true"`
-    UrlValidation    bool   `default:"false" split_words:"true"`
+    ConfigFile                 string        `default:"" split_words:"true"`
+    Product                    string        `default:"" split_words:"true"`
+    Products                   []ProductType `ignored:"true"`
+    DefaultGroup               string        `default:"v1" split_words:"true" reload:"true" product:"true"`
+    DefaultChannel             string        `default:"stable" split_words:"true" reload:"true" product:"true"`
+    Channels                   []string      `default:"rock-solid,stable,ea,beta,alpha" split_words:"true" product:"true"`
+    UseLatestChannel           bool          `default:"false" split_words:"true" product:"true"`
+    ListenAddress              string        `default:"0.0.0.0" split_words:"true"`
+    ListenPort                 string        `default:"8080" split_words:"true"`
+    ListenSocket               string        `default:"" split_words:"true"`
+    ListenSocketMode           string        `default:"0660" split_words:"true"`
+    LogLevel                   string        `default:"warn" split_words:"true" reload:"true"`
+    LogFormat                  string        `default:"text" split_words:"true" reload:"true"`
+    PathChannelsFile           string        `default:"channels.yaml" split_words:"true" reload:"true" product:"true"`
+    ChannelsGitDir             string        `split_words:"true" reload:"true" product:"true"`
+    ChannelsPublicKeys         []string      `split_words:"true" reload:"true" product:"true"`
+    ChannelsPollInterval       time.Duration `default:"30s" split_words:"true" reload:"true" product:"true"`
+    ChannelsFetchTimeout       time.Duration `default:"10s" split_words:"true" reload:"true" product:"true"`
+    PathStatic                 string        `default:"root" split_words:"true" product:"true"`
+    PathTpls                   string        `default:"/includes" split_words:"true" product:"true"`
+    BannerTemplate             string        `default:"" split_words:"true" reload:"true" product:"true"`
+    RetentionKeepPatches       int           `default:"0" split_words:"true" reload:"true" product:"true"`
+    RetentionKeepSince         string        `default:"" split_words:"true" reload:"true" product:"true"`
+    ChannelOverrideChannels    []string      `split_words:"true" reload:"true" product:"true"`
+    ChannelOverrideQueryParam  string        `default:"channel" split_words:"true" reload:"true" product:"true"`
+    ChannelOverrideHeader      string        `default:"X-Vrouter-Channel" split_words:"true" reload:"true" product:"true"`
+    ChannelOverrideCookie      string        `default:"vrouter_channel" split_words:"true" reload:"true" product:"true"`
+    CanaryCookie               string        `default:"vrouter_canary" split_words:"true" reload:"true" product:"true"`
+    LocationVersions           string        `default:"/documentation" split_words:"true" product:"true"`
+    Languages                  []string      `default:"ru,en" split_words:"true" product:"true"`
+    I18nType                   string        `default:"domain" split_words:"true" product:"true"`
+    UrlValidation              bool          `default:"false" split_words:"true" reload:"true" product:"true"`
+    VersionsDiscovery          bool          `default:"false" split_words:"true" reload:"true" product:"true"`
+    ConsistencyCheckInterval   time.Duration `default:"1m" split_words:"true" product:"true"`
+    RejectInconsistentChannels bool          `default:"false" split_words:"true" reload:"true" product:"true"`
+    ReadTimeout                time.Duration `default:"15s" split_words:"true"`
+    ReadHeaderTimeout          time.Duration `default:"0s" split_words:"true"`
+    WriteTimeout               time.Duration `default:"15s" split_words:"true"`
+    IdleTimeout                time.Duration `default:"60s" split_words:"true"`
+    ShutdownTimeout            time.Duration `default:"5s" split_words:"true" reload:"true"`
+    DrainDelay                 time.Duration `default:"0s" split_words:"true" reload:"true"`
+    TlsCertFile                string        `default:"" split_words:"true"`
+    TlsKeyFile                 string        `default:"" split_words:"true"`
+    TlsReloadInterval          time.Duration `default:"1m" split_words:"true"`
+    HttpRedirectPort           string        `default:"" split_words:"true"`
+    AdminListenAddress         string        `default:"127.0.0.1" split_words:"true"`
+    AdminListenPort            string        `default:"" split_words:"true"`
+    AdminListenSocket          string        `default:"" split_words:"true"`
+    AdminUser                  string        `default:"" split_words:"true" reload:"true"`
+    AdminPassword              string        `default:"" split_words:"true" secret:"true" reload:"true"`
+    PathHistoryFile            string        `default:"" split_words:"true" product:"true"`
+    HistoryLimit               int           `default:"100" split_words:"true" reload:"true" product:"true"`
+    RejectInvalidChannels      bool          `default:"false" split_words:"true" reload:"true" product:"true"`
+}
+
+// Layout of dates in the version metadata
+const versionDateLayout = "2006-01-02"
+
+// Optional metadata of the version. Dates are in the YYYY-MM-DD format.
+type VersionMetadataType struct {
+	ReleaseDate      string `json:"releaseDate,omitempty" yaml:"releaseDate,omitempty"`
+	ReleaseNotesURL  string `json:"releaseNotesURL,omitempty" yaml:"releaseNotesURL,omitempty"`
+	EOLDate          string `json:"eolDate,omitempty" yaml:"eolDate,omitempty"` // The version isn't supported since this date
+	Deprecated       bool   `json:"deprecated,omitempty" yaml:"deprecated,omitempty"`
+	SecurityAdvisory bool   `json:"securityAdvisory,omitempty" yaml:"securityAdvisory,omitempty"` // The version has a known vulnerability
+	GitRef           string `json:"gitRef,omitempty" yaml:"gitRef,omitempty"`                     // Git ref of the version sources, e.g. a tag
 }
 
 type ChannelType struct {
-	Name    string `json:"name"`
-	Version string `json:"version"`
+	Name                string `json:"name"`
+	Version             string `json:"version"`
+	VersionMetadataType `yaml:",inline"`
+	ActivateAt          *time.Time             `json:"activateAt,omitempty" yaml:"activateAt,omitempty"` // The entry is ignored until this time
+	Schedule            []ScheduledVersionType `json:"schedule,omitempty" yaml:"schedule,omitempty"`     // Future assignments of the channel
+	Canary              []CanaryVersionType    `json:"canary,omitempty" yaml:"canary,omitempty"`         // Versions served to a part of the clients
 }
 
 type ReleaseType struct {
 	Name     string
 	Channels []ChannelType
+	Versions []string `json:",omitempty" yaml:",omitempty"` // Discovered versions, not assigned to any channel
 }
 
 type ReleasesStatusType struct {
-	Groups []ReleaseType
+	Groups   []ReleaseType
+	Upcoming []upcomingChangeType `json:"-" yaml:"-"` // Scheduled changes of channels
+	lint     lintResultType       // Lint result of the channels data
 }
 
 type APIStatusResponseType struct {
-	Status         string        `json:"status"`
-	Msg            string        `json:"msg"`
-	RootVersion    string        `json:"rootVersion"`
-	RootVersionURL string        `json:"rootVersionURL"`
-	Releases       []ReleaseType `json:"releasechannels"`
+	Status         string                    `json:"status"`
+	Msg            string                    `json:"msg"`
+	RootVersion    string                    `json:"rootVersion"`
+	RootVersionURL string                    `json:"rootVersionURL"`
+	Releases       []ReleaseType             `json:"releasechannels"`
+	Consistency    consistencyStatusType     `json:"consistency"`
+	Upcoming       []upcomingChangeType      `json:"upcoming"`
+	Lint           []lintIssueType           `json:"lint"`
+	Source         *channelsSourceStatusType `json:"source"`
+	Retention      *retentionStatusType      `json:"retention,omitempty"`
 }
 
 type templateDataType struct {
-	VersionItems           []versionMenuItems
-	HTMLContent            string
-	CurrentGroup           string
-	CurrentChannel         string
-	CurrentVersion         string
-	CurrentLang            string
-	AbsoluteVersion        string // Contains explicit version, used for getting git link to source file
-	CurrentVersionURL      string
-	CurrentPageURLRelative string // Relative URL, without "<lang>/<LocationVersions>/<version>"
-	CurrentPageURL         string // Full page URL
-	MenuDocumentationLink  string // E.g. Used for top menus
+	VersionItems           []versionMenuItems `json:"versionItems"`
+	HTMLContent            string             `json:"-"`
+	CurrentGroup           string             `json:"currentGroup"`
+	CurrentChannel         string             `json:"currentChannel"`
+	CurrentVersion         string             `json:"currentVersion"`
+	CurrentLang            string             `json:"currentLang"`
+	AbsoluteVersion        string             `json:"absoluteVersion"` // Contains explicit version, used for getting git link to source file
+	CurrentVersionURL      string             `json:"currentVersionURL"`
+	CurrentPageURLRelative string             `json:"currentPageURLRelative"`    // Relative URL, without "<lang>/<LocationVersions>/<version>"
+	CurrentPageURL         string             `json:"currentPageURL"`            // Full page URL
+	MenuDocumentationLink  string             `json:"menuDocumentationLink"`     // E.g. Used for top menus
+	ChannelOverride        string             `json:"channelOverride,omitempty"` // The channel requested by the query parameter, the header or the cookie
+	Banner                 template.HTML      `json:"-"`                         // The banner of EOL, deprecated and outdated versions
 }
 
 type versionMenuItems struct {
-	Group      string
-	Channel    string
-	Version    string
-	VersionURL string // Base URL for corresponding version without a leading /, e.g. 'v1.2.3-plus-fix6'.
-	IsCurrent  bool
+	Group               string `json:"group"`
+	Channel             string `json:"channel"`
+	Version             string `json:"version"`
+	VersionURL          string `json:"versionURL"` // Base URL for corresponding version without a leading /, e.g. 'v1.2.3-plus-fix6'.
+	IsCurrent           bool   `json:"isCurrent"`
+	VersionMetadataType        // Metadata of the version from the channels file
+	IsEOL               bool   `json:"isEOL"` // The EOL date of the version has come
 }
 
-var ReleasesStatus ReleasesStatusType
-
-var channelsListReverseStability = []string{"rock-solid", "stable", "ea", "beta", "alpha"}
+// The published channels data, see publishReleasesStatus
+var releasesStatus atomic.Value
+var releasesStatusMutex sync.Mutex
+var releasesStatusChecksum [sha256.Size]byte
 
 func ValidateConfig() {
-	if GlobalConfig.I18nType != "domain" && GlobalConfig.I18nType != "location" {
-		log.Fatalln(fmt.Sprintf("Unknown localization method specified (%s). It can be 'domain' or 'location'.", GlobalConfig.I18nType))
+	if getConfig().I18nType != "domain" && getConfig().I18nType != "location" {
+		log.Fatalln(fmt.Sprintf("Unknown localization method specified (%s). It can be 'domain' or 'location'.", getConfig().I18nType))
+	}
+	if len(getConfig().Languages) == 0 {
+		log.Fatalln("At least one language should be specified")
+	}
+	// HTTP is redirected to the HTTPS listener, which doesn't exist without TLS
+	if getConfig().HttpRedirectPort != "" && !isTLSEnabled() {
+		log.Fatalln("The HTTP redirect port can be specified only with the TLS certificate and key files")
+	}
+	// Product settings are checked for every product, the product processes check the rest
+	if isProductsSupervisor() {
+		if err := validateProducts(); err != nil {
+			log.Fatalln(err.Error())
+		}
+		return
 	}
 	// Check template directory
-	if fi, err := os.Stat(getRootFilesPath() + GlobalConfig.PathTpls); err == nil {
+	if fi, err := os.Stat(getRootFilesPath() + getConfig().PathTpls); err == nil {
 		if !fi.IsDir() {
-			log.Fatalln(fmt.Sprintf("The '%s%s' directory, specified as the templates directory — is not a directory", getRootFilesPath(), GlobalConfig.PathTpls))
+			log.Fatalln(fmt.Sprintf("The '%s%s' directory, specified as the templates directory — is not a directory", getRootFilesPath(), getConfig().PathTpls))
 		}
 	} else {
-		log.Fatalln(fmt.Sprintf("Template directory '%s' doesn't exist", GlobalConfig.PathTpls))
+		log.Fatalln(fmt.Sprintf("Template directory '%s' doesn't exist", getConfig().PathTpls))
+	}
+	// Check TLS configuration
+	if (getConfig().TlsCertFile == "") != (getConfig().TlsKeyFile == "") {
+		log.Fatalln("Both the TLS certificate and the TLS key files should be specified")
+	}
+	if _, err := parsePublicKeys(getConfig().ChannelsPublicKeys); err != nil {
+		log.Fatalln(err.Error())
+	}
+	if err := checkRetention(getConfig()); err != nil {
+		log.Fatalln(err.Error())
 	}
 	// Check channels file
-	if _, err := os.Stat(GlobalConfig.PathChannelsFile); err != nil {
+	if err := checkChannelsSource(getConfig()); err != nil {
 		if os.IsNotExist(err) {
-			log.Fatalln(fmt.Sprintf("Channels file '%s' doesn't exist", GlobalConfig.PathChannelsFile))
+			log.Fatalln(fmt.Sprintf("Channels file '%s' doesn't exist", getConfig().PathChannelsFile))
 		}
-		log.Fatalln(fmt.Sprintf("Channels file '%s' access error", GlobalConfig.PathChannelsFile))
+		log.Fatalln(fmt.Sprintf("Channels file '%s' access error (%s)", getConfig().PathChannelsFile, err.Error()))
 	}
 }
 
 func printConfiguration() {
-	log.Infoln(fmt.Sprintf("Listening on %s:%s", GlobalConfig.ListenAddress, GlobalConfig.ListenPort))
-	log.Infoln(fmt.Sprintf("Logging level is %s (format - %s)", log.GetLevel(), GlobalConfig.LogFormat))
+	if getConfig().ConfigFile != "" {
+		log.Infoln(fmt.Sprintf("Config file: %s", getConfig().ConfigFile))
+	}
+	if getConfig().ListenPort != "" {
+		log.Infoln(fmt.Sprintf("Listening on %s:%s", getConfig().ListenAddress, getConfig().ListenPort))
+	}
+	if getConfig().ListenSocket != "" {
+		log.Infoln(fmt.Sprintf("Listening on the Unix socket %s (mode %s)", getConfig().ListenSocket, getConfig().ListenSocketMode))
+	}
+	if isTLSEnabled() {
+		log.Infoln(fmt.Sprintf("TLS certificate: %s, key: %s (reload interval - %s)", getConfig().TlsCertFile, getConfig().TlsKeyFile, getConfig().TlsReloadInterval))
+	}
+	if getConfig().HttpRedirectPort != "" {
+		log.Infoln(fmt.Sprintf("Redirecting HTTP to HTTPS on %s:%s", getConfig().ListenAddress, getConfig().HttpRedirectPort))
+	}
+	if isAdminListenerEnabled() {
+		log.Infoln(fmt.Sprintf("Admin endpoints are listening on %s (basic auth - %v)", getAdminListenAddress(), getConfig().AdminUser != ""))
+	}
+	if getConfig().PathHistoryFile != "" {
+		log.Infoln(fmt.Sprintf("Channels history file: %s (keep %d snapshots)", getConfig().PathHistoryFile, getConfig().HistoryLimit))
+	}
+	log.Infoln(fmt.Sprintf("Timeouts: read - %s, read header - %s, write - %s, idle - %s", getConfig().ReadTimeout, getConfig().ReadHeaderTimeout, getConfig().WriteTimeout, getConfig().IdleTimeout))
+	log.Infoln(fmt.Sprintf("Shutdown: drain delay - %s, timeout - %s", getConfig().DrainDelay, getConfig().ShutdownTimeout))
+	log.Infoln(fmt.Sprintf("Logging level is %s (format - %s)", log.GetLevel(), getConfig().LogFormat))
 	dir, err := os.Getwd()
 	if err != nil {
 		log.Fatal(err)
 	}
 	log.Infoln(fmt.Sprintf("Working dir: %s", dir))
-	log.Infoln(fmt.Sprintf("Channel file used: %s", GlobalConfig.PathChannelsFile))
+	log.Infoln(fmt.Sprintf("Channel file used: %s", getConfig().PathChannelsFile))
+	if getConfig().ChannelsGitDir != "" {
+		log.Infoln(fmt.Sprintf("Git repository with the channel file: %s", getConfig().ChannelsGitDir))
+	}
 	log.Infoln(fmt.Sprintf("Directory with static files: %s", getRootFilesPath()))
-	log.Infoln(fmt.Sprintf("Templates directory: %s%s", getRootFilesPath(), GlobalConfig.PathTpls))
-	log.Infoln(fmt.Sprintf("URL location for versions: %s", GlobalConfig.LocationVersions))
-	log.Infoln(fmt.Sprintf("Localization method: %s", GlobalConfig.I18nType))
-	log.Infoln(fmt.Sprintf("Default group: %s", GlobalConfig.DefaultGroup))
-	log.Infoln(fmt.Sprintf("Default channel: %s", GlobalConfig.DefaultChannel))
-	log.Infoln(fmt.Sprintf("Use the 'latest' channel: %s", GlobalConfig.UseLatestChannel))
+	log.Infoln(fmt.Sprintf("Templates directory: %s%s", getRootFilesPath(), getConfig().PathTpls))
+	log.Infoln(fmt.Sprintf("URL location for versions: %s", getConfig().LocationVersions))
+	log.Infoln(fmt.Sprintf("Localization method: %s", getConfig().I18nType))
+	log.Infoln(fmt.Sprintf("Default group: %s", getConfig().DefaultGroup))
+	log.Infoln(fmt.Sprintf("Default channel: %s", getConfig().DefaultChannel))
+	log.Infoln(fmt.Sprintf("Use the 'latest' channel: %v", getConfig().UseLatestChannel))
+	log.Infoln(fmt.Sprintf("Versions discovery: %v", getConfig().VersionsDiscovery))
+	log.Infoln(fmt.Sprintf("Reject channels file with errors: %v", getConfig().RejectInvalidChannels))
+	log.Infoln(fmt.Sprintf("Consistency check interval: %s (reject inconsistent channels - %v)", getConfig().ConsistencyCheckInterval, getConfig().RejectInconsistentChannels))
 
 	if log.GetLevel() == log.TraceLevel {
-		channelFileContent, err := ioutil.ReadFile(GlobalConfig.PathChannelsFile)
+		channelFileContent, err := readChannelsData()
 
 		if err != nil {
 			log.Fatal(err)
@@ -163,7 +289,7 @@ func (m *templateDataType) getChannelMenuData(r *http.Request, releases *Release
 	m.CurrentVersion = URLToVersion(m.CurrentVersionURL)
 
 	if m.CurrentVersion == "" {
-		m.CurrentVersion = GlobalConfig.DefaultGroup
+		m.CurrentVersion = getConfig().DefaultGroup
 		m.CurrentVersionURL = VersionToURL(m.CurrentVersion)
 	}
 
@@ -184,28 +310,32 @@ func (m *templateDataType) getChannelMenuData(r *http.Request, releases *Release
 	// Add other items
 	for _, group := range getGroups() {
 		// TODO error handling
-		_ = m.getChannelsFromGroup(&ReleasesStatus, group)
+		_ = m.getChannelsFromGroup(getReleasesStatus(), group)
 	}
 
+	m.setVersionItemsMetadata(getReleasesStatus())
+
 	return
 }
 
 func (m *templateDataType) getVersionMenuData(r *http.Request) (err error) {
 	err = nil
+	releases := getRequestReleases(r)
 
 	m.CurrentPageURLRelative = getDocPageURLRelative(r, false)
 	m.CurrentPageURL = getCurrentPageURL(r)
 	m.CurrentVersionURL = getVersionURL(r)
 	m.CurrentVersion = URLToVersion(m.CurrentVersionURL)
 	m.CurrentLang = getCurrentLang(r)
+	m.ChannelOverride = getChannelOverride(r, false)
 
 	if m.CurrentVersion == "" {
-		re := regexp.MustCompile(fmt.Sprintf("^/[^/]%s/(.+)$", GlobalConfig.LocationVersions))
+		re := regexp.MustCompile(fmt.Sprintf("^/[^/]%s/(.+)$", getConfig().LocationVersions))
 		res := re.FindStringSubmatch(m.CurrentPageURL)
 		if res == nil {
 			m.MenuDocumentationLink = ""
 		} else {
-			m.CurrentVersion = GlobalConfig.DefaultGroup
+			m.CurrentVersion = getConfig().DefaultGroup
 			m.CurrentVersionURL = VersionToURL(m.CurrentVersion)
 		}
 	}
@@ -215,14 +345,19 @@ func (m *templateDataType) getVersionMenuData(r *http.Request) (err error) {
 	if res != nil {
 		if res[2] != "" {
 			// Version is not a group (MAJ.MIN), but the patch version
-			m.MenuDocumentationLink = fmt.Sprintf("%s/%s/", GlobalConfig.LocationVersions, VersionToURL(res[1]))
+			m.MenuDocumentationLink = fmt.Sprintf("%s/%s/", getConfig().LocationVersions, VersionToURL(res[1]))
 			m.AbsoluteVersion = m.CurrentVersion
 		} else {
-			m.MenuDocumentationLink = fmt.Sprintf("%s/%s/", GlobalConfig.LocationVersions, VersionToURL(m.CurrentVersion))
-			m.AbsoluteVersion, err = getVersionFromGroup(&ReleasesStatus, res[1])
+			m.MenuDocumentationLink = fmt.Sprintf("%s/%s/", getConfig().LocationVersions, VersionToURL(m.CurrentVersion))
+			m.AbsoluteVersion, err = getVersionFromGroup(releases, res[1])
 			if err != nil {
 				log.Debugln(fmt.Sprintf("getVersionMenuData: error determine absolute version for %s (got %s)", m.CurrentVersion, m.AbsoluteVersion))
 			}
+			// The group is served from the requested channel, see resolveGroupRequest
+			if version, err := getVersionFromChannelAndGroup(releases, m.ChannelOverride, res[1]); err == nil && m.ChannelOverride != "" {
+				m.AbsoluteVersion = version
+				m.CurrentChannel = m.ChannelOverride
+			}
 		}
 	}
 
@@ -238,9 +373,11 @@ func (m *templateDataType) getVersionMenuData(r *http.Request) (err error) {
 	// Add other items
 	for _, group := range getGroups() {
 		// TODO error handling
-		_ = m.getChannelsFromGroup(&ReleasesStatus, group)
+		_ = m.getChannelsFromGroup(releases, group)
 	}
 
+	m.setVersionItemsMetadata(getReleasesStatus())
+
 	return
 }
 
@@ -254,7 +391,7 @@ func (m *templateDataType) getGroupMenuData(r *http.Request) (err error) {
 	m.CurrentLang = getCurrentLang(r)
 
 	if m.CurrentVersion == "" {
-		m.CurrentVersion = GlobalConfig.DefaultGroup
+		m.CurrentVersion = getConfig().DefaultGroup
 		m.CurrentVersionURL = VersionToURL(m.CurrentVersion)
 	}
 
@@ -302,7 +439,7 @@ func (m *templateDataType) getGroupMenuData(r *http.Request) (err error) {
 func (m *templateDataType) getChannelsFromGroup(releases *ReleasesStatusType, group string) (err error) {
 	for _, item := range releases.Groups {
 		if item.Name == group {
-			for _, channel := range channelsListReverseStability {
+			for _, channel := range getConfig().Channels {
 				for _, channelItem := range item.Channels {
 					if channelItem.Name == channel {
 						m.VersionItems = append(m.VersionItems, versionMenuItems{
@@ -315,11 +452,54 @@ func (m *templateDataType) getChannelsFromGroup(releases *ReleasesStatusType, gr
 					}
 				}
 			}
+			for _, version := range item.Versions {
+				m.VersionItems = append(m.VersionItems, versionMenuItems{
+					Group:      group,
+					Channel:    "",
+					Version:    version,
+					VersionURL: VersionToURL(version),
+					IsCurrent:  false,
+				})
+			}
 		}
 	}
 	return
 }
 
+// Fill metadata of the menu items from the channels data
+func (m *templateDataType) setVersionItemsMetadata(releases *ReleasesStatusType) {
+	now := time.Now()
+	for i, item := range m.VersionItems {
+		if item.Version == "" {
+			continue
+		}
+		m.VersionItems[i].VersionMetadataType = getVersionMetadata(releases, item.Version)
+		m.VersionItems[i].IsEOL = isVersionEOL(m.VersionItems[i].VersionMetadataType, now)
+	}
+}
+
+// Get metadata of the version from the first channel entry of the version, which has metadata
+func getVersionMetadata(releases *ReleasesStatusType, version string) VersionMetadataType {
+	version = strings.TrimPrefix(version, "v")
+	for _, group := range releases.Groups {
+		for _, channel := range group.Channels {
+			if strings.TrimPrefix(channel.Version, "v") == version && channel.VersionMetadataType != (VersionMetadataType{}) {
+				return channel.VersionMetadataType
+			}
+		}
+	}
+	return VersionMetadataType{}
+}
+
+// Check whether the EOL date of the version has come
+func isVersionEOL(metadata VersionMetadataType, now time.Time) bool {
+	if metadata.EOLDate == "" {
+		return false
+	}
+	eolDate, err := time.Parse(versionDateLayout, metadata.EOLDate)
+	return err == nil && !now.Before(eolDate)
+}
+
 // Get channel and group for specified version
 func getChannelAndGroupFromVersion(releases *ReleasesStatusType, version string) (channel, group string) {
 
@@ -330,7 +510,7 @@ func getChannelAndGroupFromVersion(releases *ReleasesStatusType, version string)
 	}
 
 	for _, group := range getGroups() {
-		for _, channel := range channelsListReverseStability {
+		for _, channel := range getConfig().Channels {
 			for _, releaseItem := range releases.Groups {
 				if releaseItem.Name == group {
 					for _, channelItem := range releaseItem.Channels {
@@ -380,6 +560,9 @@ func getVersionFromGroup(releases *ReleasesStatusType, group string) (version st
 					return releaseVersions["beta"], nil
 				} else if _, ok := releaseVersions["alpha"]; ok {
 					return releaseVersions["alpha"], nil
+				} else if len(ReleaseGroup.Versions) > 0 {
+					// The group has only discovered versions, the first one is the latest
+					return ReleaseGroup.Versions[0], nil
 				}
 			}
 		}
@@ -390,11 +573,9 @@ func getVersionFromGroup(releases *ReleasesStatusType, group string) (version st
 }
 
 func getRootReleaseVersion() string {
-	_ = updateReleasesStatus()
-
-	if len(ReleasesStatus.Groups) > 0 {
-		for _, ReleaseGroup := range ReleasesStatus.Groups {
-			if ReleaseGroup.Name == GlobalConfig.DefaultGroup {
+	if releases := getReleasesStatus(); len(releases.Groups) > 0 {
+		for _, ReleaseGroup := range releases.Groups {
+			if ReleaseGroup.Name == getConfig().DefaultGroup {
 				releaseVersions := make(map[string]string)
 				for _, channel := range ReleaseGroup.Channels {
 					releaseVersions[channel.Name] = channel.Version
@@ -443,7 +624,7 @@ func getCurrentLang(r *http.Request) (result string) {
 		return
 	}
 
-	re := regexp.MustCompile(fmt.Sprintf("^/(ru|en)%s/.+$", GlobalConfig.LocationVersions))
+	re := regexp.MustCompile(fmt.Sprintf("^/(%s)%s/.+$", getLangPattern(), getConfig().LocationVersions))
 	res := re.FindStringSubmatch(originalURI.Path)
 	if res != nil {
 		result = res[1]
@@ -477,7 +658,7 @@ func getDocPageURLRelative(r *http.Request, useURI bool) (result string) {
 	}
 	URLtoParse = originalURI.Path
 
-	re := regexp.MustCompile(fmt.Sprintf("^/(ru|en)(%s/[^/]+)?/(.*)$", GlobalConfig.LocationVersions))
+	re := regexp.MustCompile(fmt.Sprintf("^/(%s)(%s/[^/]+)?/(.*)$", getLangPattern(), getConfig().LocationVersions))
 	res := re.FindStringSubmatch(URLtoParse)
 	if res != nil {
 		if len(res[2]) > 0 {
@@ -509,7 +690,7 @@ func getVersionURL(r *http.Request) (result string) {
 		URLtoParse = originalURI.Path
 	}
 
-	re := regexp.MustCompile(fmt.Sprintf("^/(ru|en)%s/([^/]+)/?.*$", GlobalConfig.LocationVersions))
+	re := regexp.MustCompile(fmt.Sprintf("^/(%s)%s/([^/]+)/?.*$", getLangPattern(), getConfig().LocationVersions))
 	res := re.FindStringSubmatch(URLtoParse)
 	if res != nil {
 		result = res[2]
@@ -531,7 +712,7 @@ func URLToVersion(version string) (result string) {
 }
 
 func validateURL(url string) (err error) {
-	if ! GlobalConfig.UrlValidation {
+	if !getConfig().UrlValidation {
 		return nil
 	}
 
@@ -558,7 +739,11 @@ func validateURL(url string) (err error) {
 
 	for {
 		resp, err = client.Get(url)
-		log.Tracef("Validating %s (tries-%v):\nStatus - %v\nHeader - %+v,", url, tries, resp.Status, resp.Header)
+		if err != nil {
+			log.Tracef("Validating %s (tries-%v):\nError - %v", url, tries, err)
+		} else {
+			log.Tracef("Validating %s (tries-%v):\nStatus - %v\nHeader - %+v,", url, tries, resp.Status, resp.Header)
+		}
 		if err == nil && (resp.StatusCode == 301 || resp.StatusCode == 302) {
 			if len(resp.Header.Get("Location")) > 0 {
 				url = resp.Header.Get("Location")
@@ -585,7 +770,7 @@ func validateURL(url string) (err error) {
 
 // Get update channel groups in a descending order.
 func getGroups() (groups []string) {
-	for _, item := range ReleasesStatus.Groups {
+	for _, item := range getReleasesStatus().Groups {
 		groups = append(groups, item.Name)
 	}
 	// TODO compare groups function
@@ -603,14 +788,23 @@ func getGroups() (groups []string) {
 	return
 }
 
+// Regexp alternation of the languages, e.g. 'ru|en'
+func getLangPattern() string {
+	var items []string
+	for _, lang := range getConfig().Languages {
+		items = append(items, regexp.QuoteMeta(lang))
+	}
+	return strings.Join(items, "|")
+}
+
 func getRootFilesPath() string {
-	return GlobalConfig.PathStatic
+	return getConfig().PathStatic
 }
 
 func unmarshalJSON(data []byte, config interface{}) error {
 	err := json.Unmarshal(data, config)
 	if err != nil {
-		log.Errorf("Can't unmarshall %s (%e)", GlobalConfig.PathChannelsFile, err)
+		log.Errorf("Can't unmarshall %s (%e)", getConfig().PathChannelsFile, err)
 		return err
 	}
 	return nil
@@ -619,22 +813,111 @@ func unmarshalJSON(data []byte, config interface{}) error {
 func unmarshalYAML(data []byte, config interface{}) error {
 	err := yaml.Unmarshal(data, config)
 	if err != nil {
-		log.Errorf("Can't unmarshall %s (%e)", GlobalConfig.PathChannelsFile, err)
+		log.Errorf("Can't unmarshall %s (%e)", getConfig().PathChannelsFile, err)
 		return err
 	}
 	return nil
 }
 
-func updateReleasesStatus() error {
-	data, err := ioutil.ReadFile(GlobalConfig.PathChannelsFile)
+func updateReleasesStatus() (err error) {
+	defer func() { setReleasesLoadState(err) }()
+
+	channelsFileMutex.RLock()
+	defer channelsFileMutex.RUnlock()
+
+	// The state is taken before reading, so a change during the reading is loaded on the next check
+	state := getChannelsSourceState()
+	defer func() {
+		channelsSourceState.Lock()
+		channelsSourceState.State = state
+		channelsSourceState.Unlock()
+	}()
+
+	data, err := readChannelsData()
+	if err != nil {
+		log.Errorf("Can't open %s (%e)", getConfig().PathChannelsFile, err)
+		return err
+	}
+
+	releases, err := loadReleasesStatus(data, getChannelsFileName(getConfig().PathChannelsFile))
 	if err != nil {
-		log.Errorf("Can't open %s (%e)", GlobalConfig.PathChannelsFile, err)
 		return err
 	}
-	if strings.HasSuffix(GlobalConfig.PathChannelsFile, ".json") {
-		return unmarshalJSON(data, &ReleasesStatus)
-	} else if strings.HasSuffix(GlobalConfig.PathChannelsFile, ".yaml") || strings.HasSuffix(GlobalConfig.PathChannelsFile, ".yml") {
-		return unmarshalYAML(data, &ReleasesStatus)
+
+	publishReleasesStatus(releases, data, getChannelsSourceType())
+	return nil
+}
+
+// Get the published channels data. It's shared by concurrent requests and must not be changed.
+func getReleasesStatus() *ReleasesStatusType {
+	if releases, ok := releasesStatus.Load().(*ReleasesStatusType); ok {
+		return releases
+	}
+	return &ReleasesStatusType{}
+}
+
+// Publish the channels data loaded from the data for requests. Data depending on the channels data
+// (the consistency status, the history) is updated only when the published data changes.
+// The lint issues are updated on every publish, as the data is linted again on the configuration reload.
+func publishReleasesStatus(releases ReleasesStatusType, data []byte, source string) {
+	releasesStatusMutex.Lock()
+	defer releasesStatusMutex.Unlock()
+
+	updatePublishedLintIssues(releases.lint)
+
+	checksum := sha256.Sum256(data)
+	if previous, ok := releasesStatus.Load().(*ReleasesStatusType); ok && checksum == releasesStatusChecksum && reflect.DeepEqual(*previous, releases) {
+		return
+	}
+	releasesStatus.Store(&releases)
+	releasesStatusChecksum = checksum
+	updateRetiredVersions(&releases)
+	updateConsistencyStatus(&releases)
+	recordHistory(data, source)
+}
+
+// Decode the channels data. The format is chosen by the file extension.
+func decodeReleasesStatus(data []byte, filename string) (releases ReleasesStatusType, err error) {
+	if strings.HasSuffix(filename, ".json") {
+		err = unmarshalJSON(data, &releases)
+	} else if strings.HasSuffix(filename, ".yaml") || strings.HasSuffix(filename, ".yml") {
+		err = unmarshalYAML(data, &releases)
+	} else {
+		err = fmt.Errorf("failed to decode channels file %s", filename)
+	}
+	return
+}
+
+// Decode and check the channels data
+func loadReleasesStatus(data []byte, filename string) (releases ReleasesStatusType, err error) {
+	releases, err = decodeReleasesStatus(data, filename)
+	if err != nil {
+		return releases, err
+	}
+
+	releases.lint, err = lintOnLoad(data, filename)
+	if err != nil {
+		return releases, err
+	}
+	if getConfig().RejectInvalidChannels && hasLintErrors(releases.lint.Issues) {
+		err = fmt.Errorf("channels file %s has errors, keeping the previous channels data", filename)
+		log.Errorln(err.Error())
+		return releases, err
+	}
+
+	applyChannelsSchedule(&releases, time.Now())
+
+	if getConfig().VersionsDiscovery {
+		discoverVersions(&releases)
 	}
-	return fmt.Errorf("failed to decode channels file %s", GlobalConfig.PathChannelsFile)
+
+	if getConfig().RejectInconsistentChannels {
+		if missing := checkChannelsConsistency(&releases); len(missing) > 0 {
+			err = fmt.Errorf("channels file %s references %d missing version directories, keeping the previous channels data", filename, len(missing))
+			log.Errorln(err.Error())
+			return releases, err
+		}
+	}
+
+	return releases, nil
 }
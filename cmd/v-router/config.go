@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/kelseyhightower/envconfig"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Load the configuration: defaults, then the config file (VROUTER_CONFIG_FILE), if specified,
+// then environment variables, which override the config file
+func loadConfig() (config GlobalConfigType, err error) {
+	if err = envconfig.Process("VROUTER", &config); err != nil {
+		return
+	}
+	if config.ConfigFile == "" {
+		return
+	}
+
+	fromEnv := config
+	if err = readConfigFile(config.ConfigFile, &config); err != nil {
+		return
+	}
+	defer func() {
+		// Settings of the product override both the config file and environment variables
+		if err == nil && config.Product != "" {
+			err = applyProductConfig(&config)
+		}
+	}()
+
+	keys, err := getConfigEnvKeys()
+	if err != nil {
+		return
+	}
+	value := reflect.ValueOf(&config).Elem()
+	envValue := reflect.ValueOf(fromEnv)
+	for i := 0; i < value.NumField(); i++ {
+		if _, ok := os.LookupEnv(keys[value.Type().Field(i).Name]); ok {
+			value.Field(i).Set(envValue.Field(i))
+		}
+	}
+	return
+}
+
+// Get environment variable names of the configuration fields, e.g. 'PathStatic' -> 'VROUTER_PATH_STATIC'
+func getConfigEnvKeys() (map[string]string, error) {
+	var buf bytes.Buffer
+
+	if err := envconfig.Usagef("VROUTER", &GlobalConfigType{}, &buf, "{{range .}}{{.Name}} {{.Key}}\n{{end}}"); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]string)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if items := strings.Fields(line); len(items) == 2 {
+			keys[items[0]] = items[1]
+		}
+	}
+	return keys, nil
+}
+
+// Keys of sections, which are not joined into field names, and keys of previous versions.
+// E.g. 'listeners: {public: {port: 8080}}' is the same as 'listenPort: 8080'.
+var configKeyAliases = map[string]string{
+	"languages.list":        "languages",
+	"languages.i18nType":    "i18nType",
+	"channels.list":         "channels",
+	"channels.default":      "defaultChannel",
+	"channels.defaultGroup": "defaultGroup",
+	"channels.useLatest":    "useLatestChannel",
+	"channels.file":         "pathChannelsFile",
+	"channels.override":     "channelOverride",
+	"redirects.httpPort":    "httpRedirectPort",
+	"listeners.public":      "listen",
+	"listeners.admin":       "adminListen",
+	"staticFileDirectory":   "pathStatic",
+}
+
+// Get the key of the field for the config file key, e.g. 'listeners.public.port' -> 'listen.port'
+func resolveConfigKeyAlias(key string) string {
+	for alias, target := range configKeyAliases {
+		if key == alias || strings.HasPrefix(key, alias+".") {
+			return target + strings.TrimPrefix(key, alias)
+		}
+	}
+	return key
+}
+
+// Read the config file in YAML, JSON or TOML format (by the file extension) over the config.
+// Keys are field names of GlobalConfigType in camel case, e.g. 'pathStatic'. Nested sections
+// are joined with the keys, e.g. 'admin: {listenPort: 8081}' is the same as 'adminListenPort: 8081',
+// see also configKeyAliases. Unknown keys are rejected.
+func readConfigFile(filename string, config *GlobalConfigType) error {
+	var values map[string]interface{}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	switch filepath.Ext(filename) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".json":
+		err = json.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("unknown format of config file %s, it can be YAML, JSON or TOML", filename)
+	}
+	if err != nil {
+		return fmt.Errorf("can't decode config file %s (%s)", filename, err.Error())
+	}
+
+	// Products are the only list of sections
+	if items, ok := values["products"]; ok {
+		if config.Products, err = parseProducts(items); err != nil {
+			return fmt.Errorf("config file %s: %s", filename, err.Error())
+		}
+		delete(values, "products")
+	}
+
+	flat := make(map[string]interface{})
+	flattenConfigValues("", values, flat)
+
+	var keys []string
+	for key := range flat {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	value := reflect.ValueOf(config).Elem()
+	for _, key := range keys {
+		field, ok := getConfigField(value, strings.ReplaceAll(resolveConfigKeyAlias(key), ".", ""))
+		if !ok {
+			return fmt.Errorf("unknown key %s in config file %s", key, filename)
+		}
+		if err := setConfigField(field, flat[key]); err != nil {
+			return fmt.Errorf("wrong value of %s in config file %s (%s)", key, filename, err.Error())
+		}
+	}
+	return nil
+}
+
+// Collect values of nested sections with keys joined with dots, e.g. 'admin.listenPort'
+func flattenConfigValues(prefix string, values map[string]interface{}, result map[string]interface{}) {
+	for key, item := range values {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if section, ok := item.(map[string]interface{}); ok {
+			flattenConfigValues(key, section, result)
+		} else {
+			result[key] = item
+		}
+	}
+}
+
+// Get the configuration field by the key, case-insensitively.
+// ConfigFile and Product can't be set in the config file.
+func getConfigField(config reflect.Value, key string) (reflect.Value, bool) {
+	for i := 0; i < config.NumField(); i++ {
+		name := config.Type().Field(i).Name
+		if strings.EqualFold(name, key) && name != "ConfigFile" && name != "Product" && name != "Products" {
+			return config.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func setConfigField(field reflect.Value, item interface{}) error {
+	switch {
+	case field.Type() == reflect.TypeOf(time.Duration(0)):
+		duration, err := time.ParseDuration(fmt.Sprint(item))
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+	case field.Kind() == reflect.String:
+		switch item.(type) {
+		case string, int, int64, float64:
+			field.SetString(fmt.Sprint(item))
+		default:
+			return fmt.Errorf("should be a string")
+		}
+	case field.Kind() == reflect.Bool:
+		flag, ok := item.(bool)
+		if !ok {
+			return fmt.Errorf("should be a boolean")
+		}
+		field.SetBool(flag)
+	case field.Kind() == reflect.Int:
+		switch number := item.(type) {
+		case int:
+			field.SetInt(int64(number))
+		case int64:
+			field.SetInt(number)
+		case float64:
+			if number != float64(int64(number)) {
+				return fmt.Errorf("should be an integer")
+			}
+			field.SetInt(int64(number))
+		default:
+			return fmt.Errorf("should be an integer")
+		}
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		var list []string
+		switch items := item.(type) {
+		case string:
+			list = strings.Split(items, ",")
+		case []interface{}:
+			for _, listItem := range items {
+				str, ok := listItem.(string)
+				if !ok {
+					return fmt.Errorf("should be a list of strings")
+				}
+				list = append(list, str)
+			}
+		default:
+			return fmt.Errorf("should be a list of strings")
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// Reload the configuration and load the channels data with it. Only fields tagged with `reload:"true"` are applied,
+// changes of other fields are logged and need a restart.
+func reloadConfig() error {
+	if err := applyReloadedConfig(); err != nil {
+		return err
+	}
+	// Errors of the channels data are logged and shown in /status, the configuration is applied anyway
+	_ = updateReleasesStatus()
+	return nil
+}
+
+func applyReloadedConfig() error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if config.PathChannelsFile != getConfig().PathChannelsFile || config.ChannelsGitDir != getConfig().ChannelsGitDir {
+		if err := checkChannelsSource(&config); err != nil {
+			return fmt.Errorf("channels file '%s' access error (%s)", config.PathChannelsFile, err.Error())
+		}
+	}
+
+	if _, err := parsePublicKeys(config.ChannelsPublicKeys); err != nil {
+		return err
+	}
+	if err := checkRetention(&config); err != nil {
+		return err
+	}
+
+	// Handlers read the configuration while loading the channels file, so block loading while changing it.
+	// The changed configuration is a copy, published as a whole.
+	channelsFileMutex.Lock()
+	defer channelsFileMutex.Unlock()
+
+	current := *getConfig()
+	value := reflect.ValueOf(&current).Elem()
+	newValue := reflect.ValueOf(config)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if reflect.DeepEqual(value.Field(i).Interface(), newValue.Field(i).Interface()) {
+			continue
+		}
+		if field.Tag.Get("reload") != "true" {
+			log.Warnln(fmt.Sprintf("Config reload: %s is changed, restart to apply", field.Name))
+			continue
+		}
+		value.Field(i).Set(newValue.Field(i))
+		log.Infoln(fmt.Sprintf("Config reload: %s is changed", field.Name))
+	}
+	setConfig(current)
+
+	Setup()
+	// Lint and check the channels file again with the new configuration
+	resetLintCache()
+	resetSignatureCache()
+	resetChannelsFragmentsCache()
+	updateRetiredVersions(getReleasesStatus())
+	return nil
+}
+
+// Reload the configuration on SIGHUP
+func runConfigReloader() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for range c {
+		log.Infoln("Got SIGHUP signal, reloading the configuration")
+		if err := reloadConfig(); err != nil {
+			log.Errorln(fmt.Sprintf("Can't reload the configuration (%s)", err.Error()))
+		}
+		signalProducts(syscall.SIGHUP)
+	}
+}
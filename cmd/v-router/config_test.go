@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "v-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv("VROUTER_CONFIG_FILE")
+	defer os.Unsetenv("VROUTER_DEFAULT_GROUP")
+
+	files := map[string]string{
+		"config.yaml": "defaultGroup: v2\nlanguages: [en, de]\nadmin:\n  listenPort: 8081\nshutdownTimeout: 10s\n",
+		"config.json": `{"defaultGroup": "v2", "languages": ["en", "de"], "admin": {"listenPort": "8081"}, "shutdownTimeout": "10s"}`,
+		"config.toml": "defaultGroup = \"v2\"\nlanguages = [\"en\", \"de\"]\nshutdownTimeout = \"10s\"\n[admin]\nlistenPort = 8081\n",
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Setenv("VROUTER_CONFIG_FILE", filename)
+
+		config, err := loadConfig()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err.Error())
+		}
+		if config.DefaultGroup != "v2" || config.AdminListenPort != "8081" || config.ShutdownTimeout != 10*time.Second ||
+			len(config.Languages) != 2 || config.Languages[1] != "de" {
+			t.Errorf("%s: wrong config %+v", name, config)
+		}
+		// Defaults are kept for the fields missing in the config file
+		if config.DefaultChannel != "stable" {
+			t.Errorf("%s: wrong default channel %s", name, config.DefaultChannel)
+		}
+	}
+
+	// Environment variables override the config file
+	os.Setenv("VROUTER_DEFAULT_GROUP", "v3")
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.DefaultGroup != "v3" {
+		t.Errorf("Environment variable should override the config file, got default group %s", config.DefaultGroup)
+	}
+
+	filename := filepath.Join(dir, "unknown.yaml")
+	if err := ioutil.WriteFile(filename, []byte("admin:\n  listenProt: 8081\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("VROUTER_CONFIG_FILE", filename)
+	if _, err := loadConfig(); err == nil {
+		t.Errorf("Unknown keys should be rejected")
+	}
+
+	// Sections and keys of previous versions
+	if err := ioutil.WriteFile(filename, []byte("languages:\n  list: [en]\n  i18nType: location\nchannels:\n  default: beta\n  file: /tmp/channels.json\nlisteners:\n  public: {port: 8090}\n  admin: {port: 8091}\nredirects:\n  httpPort: 8000\nstaticFileDirectory: ./root\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if config, err = loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Languages) != 1 || config.I18nType != "location" || config.DefaultChannel != "beta" || config.PathChannelsFile != "/tmp/channels.json" ||
+		config.ListenPort != "8090" || config.AdminListenPort != "8091" || config.HttpRedirectPort != "8000" || config.PathStatic != "./root" {
+		t.Errorf("Wrong config from sections %+v", config)
+	}
+
+	// The config file of the repository
+	os.Setenv("VROUTER_CONFIG_FILE", "../../config/config.yml")
+	if _, err := loadConfig(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "v-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv("VROUTER_CONFIG_FILE")
+	defer func(config GlobalConfigType) {
+		setConfig(config)
+	}(*getConfig())
+
+	filename := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(filename, []byte("defaultChannel: beta\nlistenPort: 8090\npath:\n  channelsFile: testdata/channels.yaml\n  static: testdata/root\ni18nType: location\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("VROUTER_CONFIG_FILE", filename)
+
+	previous := getConfig()
+	if err := reloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	// The new configuration is published as a whole, the previous one isn't changed
+	if config := getConfig(); config == previous || config.DefaultChannel != "beta" || config.ListenPort != previous.ListenPort {
+		t.Errorf("Wrong reloaded config %+v", config)
+	}
+	if previous.DefaultChannel != "stable" {
+		t.Errorf("Previous config is changed: %+v", previous)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"sync"
+	"time"
+)
+
+type missingVersionType struct {
+	Group   string `json:"group"`
+	Channel string `json:"channel"`
+	Version string `json:"version"`
+	Lang    string `json:"lang"`
+	Path    string `json:"path"`
+}
+
+type consistencyStatusType struct {
+	Status    string               `json:"status"`
+	CheckedAt time.Time            `json:"checkedAt"`
+	Missing   []missingVersionType `json:"missing"`
+}
+
+var consistencyStatus consistencyStatusType
+var consistencyStatusMutex sync.RWMutex
+
+// Check that every version referenced in the channels file has a directory
+// in the static files directory for every language
+func checkChannelsConsistency(releases *ReleasesStatusType) (missing []missingVersionType) {
+	exists := make(map[string]bool)
+	for _, group := range releases.Groups {
+		for _, channel := range group.Channels {
+			versions := []string{channel.Version}
+			for _, canary := range channel.Canary {
+				versions = append(versions, canary.Version)
+			}
+			for _, version := range versions {
+				for _, lang := range getConfig().Languages {
+					versionPath := fmt.Sprintf("%s/%s", getVersionsPath(lang), VersionToURL(version))
+					if _, ok := exists[versionPath]; !ok {
+						fi, err := os.Stat(versionPath)
+						exists[versionPath] = err == nil && fi.IsDir()
+					}
+					if exists[versionPath] {
+						continue
+					}
+					missing = append(missing, missingVersionType{
+						Group:   group.Name,
+						Channel: channel.Name,
+						Version: version,
+						Lang:    lang,
+						Path:    versionPath,
+					})
+				}
+			}
+		}
+	}
+	return
+}
+
+// Check the channels data and save the result
+func updateConsistencyStatus(releases *ReleasesStatusType) consistencyStatusType {
+	result := consistencyStatusType{
+		Status:    "ok",
+		CheckedAt: time.Now(),
+		Missing:   checkChannelsConsistency(releases),
+	}
+	if len(result.Missing) > 0 {
+		result.Status = "error"
+		for _, item := range result.Missing {
+			log.Warnln(fmt.Sprintf("Consistency check: version %s (group %s, channel %s) has no directory %s", item.Version, item.Group, item.Channel, item.Path))
+		}
+	}
+
+	consistencyStatusMutex.Lock()
+	consistencyStatus = result
+	consistencyStatusMutex.Unlock()
+	return result
+}
+
+func getConsistencyStatus() consistencyStatusType {
+	consistencyStatusMutex.RLock()
+	defer consistencyStatusMutex.RUnlock()
+	return consistencyStatus
+}
+
+// Periodically check consistency of the published channels data. The consistency status is updated
+// when the channels data is published, the checker catches version directories added or removed later.
+// Discovered versions are found again too.
+func runConsistencyChecker() {
+	if getConfig().ConsistencyCheckInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(getConfig().ConsistencyCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if getConfig().VersionsDiscovery {
+			_ = updateReleasesStatus()
+		}
+		updateConsistencyStatus(getReleasesStatus())
+		updateRetiredVersions(getReleasesStatus())
+	}
+}
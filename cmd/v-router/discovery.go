@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Add versions found in the static files directory to the corresponding groups.
+// Versions already assigned to a channel are skipped, groups missing in the channels file are created.
+func discoverVersions(releases *ReleasesStatusType) {
+	assigned := make(map[string]bool)
+	for _, group := range releases.Groups {
+		for _, channel := range group.Channels {
+			assigned[channel.Version] = true
+		}
+	}
+
+	for _, version := range getStaticVersions() {
+		if assigned[version] {
+			continue
+		}
+		versionItem, err := parseVersion(version)
+		if err != nil {
+			continue
+		}
+		idx := getGroupIndexForVersion(releases, versionItem)
+		if idx < 0 {
+			releases.Groups = append(releases.Groups, ReleaseType{Name: getGroupNameForVersion(versionItem)})
+			idx = len(releases.Groups) - 1
+		}
+		releases.Groups[idx].Versions = append(releases.Groups[idx].Versions, version)
+	}
+
+	// Discovered versions are listed in a descending order
+	for idx := range releases.Groups {
+		versions := releases.Groups[idx].Versions
+		sort.Slice(versions, func(i, j int) bool {
+			a, _ := parseVersion(versions[i])
+			b, _ := parseVersion(versions[j])
+			return compareVersions(a, b) > 0
+		})
+	}
+}
+
+// Get index of the group the version belongs to. The group name can be
+// either MAJ or MAJ.MIN, with or without the leading 'v' (e.g. 'v1', '1.2').
+// Returns -1 if there is no such group.
+func getGroupIndexForVersion(releases *ReleasesStatusType, version versionType) int {
+	for idx, group := range releases.Groups {
+		name := strings.TrimPrefix(group.Name, "v")
+		if name == fmt.Sprintf("%d.%d", version.Major, version.Minor) || name == fmt.Sprintf("%d", version.Major) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// Get name for a group created for the discovered version, following the default group naming.
+func getGroupNameForVersion(version versionType) string {
+	if strings.HasPrefix(getConfig().DefaultGroup, "v") {
+		return fmt.Sprintf("v%d", version.Major)
+	}
+	return fmt.Sprintf("%d", version.Major)
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -21,11 +22,11 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
-	err := updateReleasesStatus()
-	if err != nil {
+	if err := getReleasesLoadError(); err != nil {
 		msg = append(msg, err.Error())
 		status = "error"
 	}
+	releases := getReleasesStatus()
 
 	_ = json.NewEncoder(w).Encode(
 		APIStatusResponseType{
@@ -33,63 +34,67 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 			Msg:            strings.Join(msg, " "),
 			RootVersion:    getRootReleaseVersion(),
 			RootVersionURL: VersionToURL(getRootReleaseVersion()),
-			Releases:       ReleasesStatus.Groups,
+			Releases:       releases.Groups,
+			Consistency:    getConsistencyStatus(),
+			Upcoming:       releases.Upcoming,
+			Lint:           getLintIssues(),
+			Source:         getChannelsSourceStatus(),
+			Retention:      getRetentionStatus(),
 		})
 }
 
+// List snapshots of the channels file, the latest first
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(getHistory())
+}
+
+// Get the snapshot of the channels file, including the content
+func historyEntryHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	entry, err := getHistoryEntry(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(entry)
+}
+
 // X-Redirect to the stablest documentation version for specific group
 func groupHandler(w http.ResponseWriter, r *http.Request) {
-	var langPrefix string
-
-	_ = updateReleasesStatus()
 	log.Debugln("Use handler - groupHandler")
 
-	vars := mux.Vars(r)
-	if len(vars["lang"]) > 0 {
-		langPrefix = fmt.Sprintf("/%s", vars["lang"])
-	}
-
-	if version, err := getVersionFromGroup(&ReleasesStatus, vars["group"]); err == nil {
-		w.Header().Set("X-Accel-Redirect", fmt.Sprintf("%s%s/%s/%s", langPrefix, GlobalConfig.LocationVersions, VersionToURL(version), getDocPageURLRelative(r, true)))
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("%s%s/%s/", langPrefix, GlobalConfig.LocationVersions, GlobalConfig.DefaultGroup), 302)
-	}
+	setChannelOverrideVary(w)
+	setChannelOverrideCookie(w, r)
+	setCanaryVary(w)
+	setCanaryCookie(w, r)
+	result := resolveGroupRequest(r)
+	observeCanaryVersion(r, mux.Vars(r)["group"], result.Version)
+	writeDocResponse(w, r, result)
 }
 
 // Handles request to /v<group>-<channel>/. E.g. /v1.2-beta/
 // Temprarily redirect to specific version
 func groupChannelHandler(w http.ResponseWriter, r *http.Request) {
-	var version, URLToRedirect, langPrefix string
-	var err error
-
 	log.Debugln("Use handler - groupChannelHandler")
 
-	pageURLRelative := "/"
-	vars := mux.Vars(r)
-	if len(vars["lang"]) > 0 {
-		langPrefix = fmt.Sprintf("/%s", vars["lang"])
-	}
-
-	_ = updateReleasesStatus()
-
-	re := regexp.MustCompile(fmt.Sprintf("^/(ru|en)%s/[^/]+/(.+)$", GlobalConfig.LocationVersions))
-	res := re.FindStringSubmatch(r.URL.RequestURI())
-	if res != nil {
-		pageURLRelative = res[2]
+	setCanaryVary(w)
+	setCanaryCookie(w, r)
+	result := resolveGroupChannelRequest(r)
+	observeCanaryVersion(r, mux.Vars(r)["group"], result.Version)
+	if result.Validation != validationOK {
+		log.Errorf("Error validating URL: %v, (original was https://%s/%s)", result.Validation, r.Host, r.URL.RequestURI())
 	}
+	writeDocResponse(w, r, result)
+}
 
-	version, err = getVersionFromChannelAndGroup(&ReleasesStatus, vars["channel"], vars["group"])
-	if err == nil {
-		URLToRedirect = fmt.Sprintf("%s%s/%s/%s", langPrefix, GlobalConfig.LocationVersions, VersionToURL(version), pageURLRelative)
-		err = validateURL(fmt.Sprintf("https://%s%s", r.Host, URLToRedirect))
-	}
+// Handles request to a version range or a partial version. E.g. /v1.2.x/, /~1.2/, /1.2.3/
+// X-Redirect to the highest matching version, found in the channels file or in the static files directory
+func versionRangeHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debugln("Use handler - versionRangeHandler")
 
-	if err != nil {
-		log.Errorf("Error validating URL: %v, (original was https://%s/%s)", err.Error(), r.Host, r.URL.RequestURI())
-		notFoundHandler(w, r)
-	} else {
-		http.Redirect(w, r, URLToRedirect, 302)
-	}
+	writeDocResponse(w, r, resolveVersionRangeRequest(r))
 }
 
 // Healthcheck handler
@@ -97,12 +102,19 @@ func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
-// Render templates
-func templateHandler(w http.ResponseWriter, r *http.Request) {
-	if err := updateReleasesStatus(); err != nil {
-		log.Println(err)
+// Readiness handler. Returns result of every readiness check
+func readinessHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	result := getReadiness()
+	if result.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
 	}
+	_ = json.NewEncoder(w).Encode(result)
+}
 
+// Render templates
+func templateHandler(w http.ResponseWriter, r *http.Request) {
 	templateData := templateDataType{
 		VersionItems:           []versionMenuItems{},
 		CurrentGroup:           "", // not used now
@@ -117,7 +129,10 @@ func templateHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	_ = templateData.getVersionMenuData(r)
+	templateData.Banner = getBanner(r, getOriginalPagePath(r))
 
+	setChannelOverrideVary(w)
+	setCanaryVary(w)
 	tplPath := getRootFilesPath() + r.URL.Path
 	tpl := template.Must(template.ParseFiles(tplPath))
 	err := tpl.Execute(w, templateData)
@@ -128,6 +143,17 @@ func templateHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Get the data of templates in JSON, e.g. for version switchers rendered in the browser
+func menuHandler(w http.ResponseWriter, r *http.Request) {
+	templateData := templateDataType{VersionItems: []versionMenuItems{}}
+	_ = templateData.getVersionMenuData(r)
+
+	setChannelOverrideVary(w)
+	setCanaryVary(w)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(templateData)
+}
+
 func serveFilesHandler(fs http.FileSystem) http.Handler {
 	fsh := http.FileServer(fs)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -148,30 +174,18 @@ func serveFilesHandler(fs http.FileSystem) http.Handler {
 }
 
 func rootDocHandler(w http.ResponseWriter, r *http.Request) {
-	var redirectTo, langPrefix string
-
 	log.Debugln("Use handler - rootDocHandler")
 
-	vars := mux.Vars(r)
-	if len(vars["lang"]) > 0 {
-		langPrefix = fmt.Sprintf("/%s", vars["lang"])
-	}
-
-	if hasSuffix, _ := regexp.MatchString(fmt.Sprintf("^/[^/]+%s/.+", GlobalConfig.LocationVersions), r.RequestURI); hasSuffix {
-		items := strings.Split(r.RequestURI, fmt.Sprintf("%s/", GlobalConfig.LocationVersions))
-		if len(items) > 1 {
-			redirectTo = strings.Join(items[1:], fmt.Sprintf("%s%s/", langPrefix, GlobalConfig.LocationVersions))
-		}
-	}
-
-	http.Redirect(w, r, fmt.Sprintf("%s%s/%s/%s", langPrefix, GlobalConfig.LocationVersions, GlobalConfig.DefaultGroup, redirectTo), 301)
+	setChannelOverrideVary(w)
+	setChannelOverrideCookie(w, r)
+	writeDocResponse(w, r, resolveRootDocRequest(r))
 }
 
 // Redirect to root documentation if request not matches any location (override 404 response)
 func notFoundHandler(w http.ResponseWriter, r *http.Request) {
 	lang := "en"
 
-	re := regexp.MustCompile(`^/(ru|en)/.*$`)
+	re := regexp.MustCompile(fmt.Sprintf("^/(%s)/.*$", getLangPattern()))
 	res := re.FindStringSubmatch(r.URL.RequestURI())
 	if res != nil {
 		lang = res[1]
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	historySourceFile      = "file"
+	historySourceAdminAPI  = "admin-api"
+	historySourceRollback  = "rollback"
+	historySourceHTTP      = "http"
+	historySourceGit       = "git"
+	historySourceFragments = "fragments"
+)
+
+type channelChangeType struct {
+	Group      string     `json:"group"`
+	Channel    string     `json:"channel"`
+	ActivateAt *time.Time `json:"activateAt,omitempty"` // Set for entries activated by the schedule
+	OldVersion string     `json:"oldVersion"`
+	NewVersion string     `json:"newVersion"`
+}
+
+// Snapshot of the channels file
+type historyEntryType struct {
+	ID        int                 `json:"id"`
+	Timestamp time.Time           `json:"timestamp"`
+	Source    string              `json:"source"`
+	Checksum  string              `json:"checksum"`
+	Commit    string              `json:"commit,omitempty"`
+	Author    string              `json:"author,omitempty"`
+	Diff      []channelChangeType `json:"diff"`
+	Content   string              `json:"content,omitempty"`
+}
+
+var history = struct {
+	sync.Mutex
+	Entries []historyEntryType
+}{}
+
+// Load the history from the history file
+func loadHistory() error {
+	if getConfig().PathHistoryFile == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(getConfig().PathHistoryFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	history.Lock()
+	defer history.Unlock()
+	if err := json.Unmarshal(data, &history.Entries); err != nil {
+		return fmt.Errorf("can't decode history file %s (%s)", getConfig().PathHistoryFile, err.Error())
+	}
+	return nil
+}
+
+// Save a snapshot of the channels file if it differs from the last one
+func recordHistory(data []byte, source string) {
+	if getConfig().PathHistoryFile == "" {
+		return
+	}
+
+	checksum := sha256.Sum256(data)
+	entry := historyEntryType{
+		Timestamp: time.Now().UTC(),
+		Source:    source,
+		Checksum:  hex.EncodeToString(checksum[:]),
+		Content:   string(data),
+	}
+	if source == historySourceGit {
+		entry.Commit, entry.Author = getRemoteChannelsRevision(data)
+	}
+
+	history.Lock()
+	defer history.Unlock()
+
+	var previous ReleasesStatusType
+	if len(history.Entries) > 0 {
+		last := history.Entries[len(history.Entries)-1]
+		if last.Checksum == entry.Checksum {
+			return
+		}
+		entry.ID = last.ID + 1
+		previous, _ = decodeReleasesStatus([]byte(last.Content), getChannelsFileName(getConfig().PathChannelsFile))
+	} else {
+		entry.ID = 1
+	}
+	current, err := decodeReleasesStatus(data, getChannelsFileName(getConfig().PathChannelsFile))
+	if err != nil {
+		return
+	}
+	entry.Diff = getChannelsDiff(&previous, &current)
+
+	history.Entries = append(history.Entries, entry)
+	if getConfig().HistoryLimit > 0 && len(history.Entries) > getConfig().HistoryLimit {
+		history.Entries = history.Entries[len(history.Entries)-getConfig().HistoryLimit:]
+	}
+	log.Infoln(fmt.Sprintf("Channels history: snapshot %d recorded (source - %s, %d changes)", entry.ID, entry.Source, len(entry.Diff)))
+
+	content, err := json.MarshalIndent(history.Entries, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(getConfig().PathHistoryFile, content)
+	}
+	if err != nil {
+		log.Errorln(fmt.Sprintf("Can't save history file %s (%s)", getConfig().PathHistoryFile, err.Error()))
+	}
+}
+
+// Get version changes of group channels.
+// Entries of the same channel are told apart by 'activateAt'.
+func getChannelsDiff(previous, current *ReleasesStatusType) (diff []channelChangeType) {
+	type channelKey struct {
+		Group, Channel, ActivateAt string
+	}
+	getKey := func(group string, channel ChannelType) channelKey {
+		key := channelKey{Group: group, Channel: channel.Name}
+		if channel.ActivateAt != nil {
+			key.ActivateAt = channel.ActivateAt.UTC().Format(time.RFC3339)
+		}
+		return key
+	}
+
+	versions := make(map[channelKey]string)
+	for _, group := range previous.Groups {
+		for _, channel := range group.Channels {
+			versions[getKey(group.Name, channel)] = channel.Version
+		}
+	}
+
+	for _, group := range current.Groups {
+		for _, channel := range group.Channels {
+			key := getKey(group.Name, channel)
+			if versions[key] != channel.Version {
+				diff = append(diff, channelChangeType{Group: group.Name, Channel: channel.Name, ActivateAt: channel.ActivateAt, OldVersion: versions[key], NewVersion: channel.Version})
+			}
+			delete(versions, key)
+		}
+	}
+
+	// Removed channels
+	for _, group := range previous.Groups {
+		for _, channel := range group.Channels {
+			if _, ok := versions[getKey(group.Name, channel)]; ok {
+				diff = append(diff, channelChangeType{Group: group.Name, Channel: channel.Name, ActivateAt: channel.ActivateAt, OldVersion: channel.Version})
+			}
+		}
+	}
+	return
+}
+
+// Get history entries, the latest first, without the file content
+func getHistory() (result []historyEntryType) {
+	history.Lock()
+	defer history.Unlock()
+	for i := len(history.Entries) - 1; i >= 0; i-- {
+		entry := history.Entries[i]
+		entry.Content = ""
+		result = append(result, entry)
+	}
+	return
+}
+
+func getHistoryEntry(id int) (historyEntryType, error) {
+	history.Lock()
+	defer history.Unlock()
+	for _, entry := range history.Entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return historyEntryType{}, fmt.Errorf("no history entry %d", id)
+}
+
+// Restore the channels file from the history entry
+func rollbackHistory(id int) error {
+	entry, err := getHistoryEntry(id)
+	if err != nil {
+		return err
+	}
+	if getChannelsSourceType() != historySourceFile {
+		return fmt.Errorf("channels source %s is read-only", getConfig().PathChannelsFile)
+	}
+	if isChannelsSignatureRequired() {
+		return fmt.Errorf("channels file %s is signed, it can't be changed without a new signature", getConfig().PathChannelsFile)
+	}
+
+	channelsFileMutex.Lock()
+	defer channelsFileMutex.Unlock()
+	return replaceChannelsFile([]byte(entry.Content), fmt.Sprintf("%s:%d", historySourceRollback, id))
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	lintSeverityError   = "error"
+	lintSeverityWarning = "warning"
+)
+
+type lintIssueType struct {
+	Severity string `json:"severity"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Msg      string `json:"msg"`
+}
+
+func (m lintIssueType) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s", m.File, m.Line, m.Column, m.Severity, m.Msg)
+}
+
+func newLintIssue(severity, filename string, node *yaml.Node, msg string) lintIssueType {
+	return lintIssueType{Severity: severity, File: filename, Line: node.Line, Column: node.Column, Msg: msg}
+}
+
+// Channel entry of the channels file with positions
+type lintChannelType struct {
+	Name        string
+	Version     string
+	IsScheduled bool
+	NameNode    *yaml.Node
+	VersionNode *yaml.Node
+}
+
+// Result of the lint of the channels data
+type lintResultType struct {
+	Checksum [sha256.Size]byte
+	Issues   []lintIssueType
+}
+
+// Result of the last lint of the channels data, published or rejected, to lint and log only changed content
+var lintCache = struct {
+	sync.Mutex
+	lintResultType
+}{}
+
+// Lint issues of the published channels data
+var publishedLintIssues = struct {
+	sync.Mutex
+	Issues []lintIssueType
+}{}
+
+// Check the channels file content. JSON is parsed as YAML to get positions.
+func lintChannelsFile(data []byte, filename string) ([]lintIssueType, error) {
+	return lintChannelsFragments([]channelsFragmentType{{Filename: filename, Data: data}}, lintSeverityError)
+}
+
+// Check the channels data of the fragments as a whole: a group can be defined only in one fragment,
+// and the default group in any of them (missing default group is an issue of the missingDefaultGroup severity).
+// Issues point to positions in the fragments.
+func lintChannelsFragments(fragments []channelsFragmentType, missingDefaultGroup string) (issues []lintIssueType, err error) {
+	var firstRoot *yaml.Node
+	var firstFilename string
+
+	defaultGroupFound := false
+	groupNames := make(map[string]lintIssueType)
+	for _, fragment := range fragments {
+		var doc yaml.Node
+		filename := fragment.Filename
+
+		if err := yaml.Unmarshal(fragment.Data, &doc); err != nil {
+			if len(fragments) > 1 {
+				return nil, fmt.Errorf("%s: %s", filename, err.Error())
+			}
+			return nil, err
+		}
+		if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("channels file %s is not a mapping", filename)
+		}
+		root := doc.Content[0]
+		if firstRoot == nil {
+			firstRoot, firstFilename = root, filename
+		}
+
+		addIssue := func(severity string, node *yaml.Node, format string, args ...interface{}) {
+			issues = append(issues, newLintIssue(severity, filename, node, fmt.Sprintf(format, args...)))
+		}
+
+		groups := getYAMLMappingValue(root, "groups")
+		if groups == nil || groups.Kind != yaml.SequenceNode {
+			addIssue(lintSeverityError, root, "no groups defined")
+			continue
+		}
+
+		issues = append(issues, lintGroups(filename, groups, groupNames, &defaultGroupFound)...)
+	}
+
+	if !defaultGroupFound && firstRoot != nil {
+		issues = append(issues, newLintIssue(missingDefaultGroup, firstFilename, firstRoot, fmt.Sprintf("default group %s is not defined", getConfig().DefaultGroup)))
+	}
+	return issues, nil
+}
+
+// Check groups of the channels file. Groups defined before, e.g. in other fragments, are in groupNames.
+func lintGroups(filename string, groups *yaml.Node, groupNames map[string]lintIssueType, defaultGroupFound *bool) (issues []lintIssueType) {
+	addIssue := func(severity string, node *yaml.Node, format string, args ...interface{}) {
+		issues = append(issues, newLintIssue(severity, filename, node, fmt.Sprintf(format, args...)))
+	}
+
+	for _, groupNode := range groups.Content {
+		nameNode := getYAMLMappingValue(groupNode, "name")
+		if nameNode == nil {
+			addIssue(lintSeverityError, groupNode, "group has no name")
+			continue
+		}
+		if first, ok := groupNames[nameNode.Value]; ok {
+			if first.File == filename {
+				addIssue(lintSeverityError, nameNode, "group %s is defined more than once", nameNode.Value)
+			} else {
+				addIssue(lintSeverityError, nameNode, "group %s is already defined in %s:%d:%d", nameNode.Value, first.File, first.Line, first.Column)
+			}
+		} else {
+			groupNames[nameNode.Value] = newLintIssue("", filename, nameNode, "")
+		}
+		if nameNode.Value == getConfig().DefaultGroup {
+			*defaultGroupFound = true
+		}
+
+		var channels []lintChannelType
+		if channelsNode := getYAMLMappingValue(groupNode, "channels"); channelsNode != nil {
+			for _, channelNode := range channelsNode.Content {
+				item := lintChannelType{
+					NameNode:    getYAMLMappingValue(channelNode, "name"),
+					VersionNode: getYAMLMappingValue(channelNode, "version"),
+					IsScheduled: getYAMLMappingValue(channelNode, "activateAt") != nil,
+				}
+				if item.NameNode == nil || item.VersionNode == nil {
+					addIssue(lintSeverityError, channelNode, "channel should have name and version")
+					continue
+				}
+				item.Name = item.NameNode.Value
+				item.Version = item.VersionNode.Value
+				channels = append(channels, item)
+
+				issues = append(issues, lintVersionMetadata(filename, channelNode)...)
+				issues = append(issues, lintCanary(filename, nameNode.Value, channelNode)...)
+				if scheduleNode := getYAMLMappingValue(channelNode, "schedule"); scheduleNode != nil {
+					issues = append(issues, lintSchedule(filename, nameNode.Value, channelNode, scheduleNode)...)
+					for _, scheduleItem := range scheduleNode.Content {
+						issues = append(issues, lintVersionMetadata(filename, scheduleItem)...)
+						issues = append(issues, lintCanary(filename, nameNode.Value, scheduleItem)...)
+					}
+				}
+			}
+		}
+		issues = append(issues, lintGroupChannels(filename, nameNode.Value, channels)...)
+	}
+	return
+}
+
+// Check that schedule items are activated after the channel entry itself,
+// otherwise they never take effect
+func lintSchedule(filename, group string, channelNode, scheduleNode *yaml.Node) (issues []lintIssueType) {
+	var entryActivateAt time.Time
+	if activateAtNode := getYAMLMappingValue(channelNode, "activateAt"); activateAtNode != nil {
+		if err := activateAtNode.Decode(&entryActivateAt); err != nil {
+			return
+		}
+	}
+	channel := ""
+	if nameNode := getYAMLMappingValue(channelNode, "name"); nameNode != nil {
+		channel = nameNode.Value
+	}
+	for _, scheduleItem := range scheduleNode.Content {
+		activateAtNode := getYAMLMappingValue(scheduleItem, "activateAt")
+		if activateAtNode == nil {
+			issues = append(issues, newLintIssue(lintSeverityError, filename, scheduleItem, fmt.Sprintf("schedule item of channel %s in group %s should have activateAt", channel, group)))
+			continue
+		}
+		var activateAt time.Time
+		if err := activateAtNode.Decode(&activateAt); err != nil {
+			issues = append(issues, newLintIssue(lintSeverityError, filename, activateAtNode, fmt.Sprintf("can't parse activateAt %s", activateAtNode.Value)))
+			continue
+		}
+		if !activateAt.After(entryActivateAt) {
+			issues = append(issues, newLintIssue(lintSeverityError, filename, activateAtNode, fmt.Sprintf("schedule item of channel %s in group %s is activated at %s, not after the entry itself, it is ignored",
+				channel, group, activateAtNode.Value)))
+		}
+	}
+	return
+}
+
+// Check the version metadata of the channel entry or the schedule item: dates and the release notes URL
+func lintVersionMetadata(filename string, node *yaml.Node) (issues []lintIssueType) {
+	for _, key := range []string{"releaseDate", "eolDate"} {
+		if dateNode := getYAMLMappingValue(node, key); dateNode != nil {
+			if _, err := time.Parse(versionDateLayout, dateNode.Value); err != nil {
+				issues = append(issues, newLintIssue(lintSeverityError, filename, dateNode, fmt.Sprintf("can't parse %s %s, it should be YYYY-MM-DD", key, dateNode.Value)))
+			}
+		}
+	}
+	if urlNode := getYAMLMappingValue(node, "releaseNotesURL"); urlNode != nil {
+		if _, err := url.Parse(urlNode.Value); err != nil {
+			issues = append(issues, newLintIssue(lintSeverityError, filename, urlNode, fmt.Sprintf("can't parse releaseNotesURL %s", urlNode.Value)))
+		}
+	}
+	return
+}
+
+// Check channels of the group: names, uniqueness, versions belong to the group,
+// versions don't decrease from more stable to less stable channels
+func lintGroupChannels(filename, group string, channels []lintChannelType) (issues []lintIssueType) {
+	addIssue := func(severity string, node *yaml.Node, format string, args ...interface{}) {
+		issues = append(issues, newLintIssue(severity, filename, node, fmt.Sprintf(format, args...)))
+	}
+
+	active := make(map[string]lintChannelType)
+	for _, channel := range channels {
+		if !isKnownChannel(channel.Name) {
+			addIssue(lintSeverityError, channel.NameNode, "unknown channel %s in group %s", channel.Name, group)
+			continue
+		}
+		versionItem, err := parseVersion(channel.Version)
+		if err != nil {
+			addIssue(lintSeverityError, channel.VersionNode, "can't parse version %s", channel.Version)
+			continue
+		}
+		if !isVersionInGroup(versionItem, group) {
+			addIssue(lintSeverityWarning, channel.VersionNode, "version %s doesn't belong to group %s", channel.Version, group)
+		}
+		// Entries with 'activateAt' replace the channel at the specified time
+		if channel.IsScheduled {
+			continue
+		}
+		if _, ok := active[channel.Name]; ok {
+			addIssue(lintSeverityError, channel.NameNode, "channel %s is defined more than once in group %s", channel.Name, group)
+			continue
+		}
+		active[channel.Name] = channel
+	}
+
+	// A less stable channel shouldn't have an older version than a more stable one
+	for i, stableChannel := range getConfig().Channels {
+		stable, ok := active[stableChannel]
+		if !ok {
+			continue
+		}
+		stableVersion, _ := parseVersion(stable.Version)
+		for _, unstableChannel := range getConfig().Channels[i+1:] {
+			unstable, ok := active[unstableChannel]
+			if !ok {
+				continue
+			}
+			unstableVersion, _ := parseVersion(unstable.Version)
+			if compareVersions(unstableVersion, stableVersion) < 0 {
+				addIssue(lintSeverityWarning, unstable.VersionNode, "channel %s has version %s older than version %s of the more stable channel %s in group %s",
+					unstableChannel, unstable.Version, stable.Version, stableChannel, group)
+			}
+		}
+	}
+	return
+}
+
+// Check whether the version belongs to the group named MAJ or MAJ.MIN (with or without the leading 'v').
+// Groups with other names match any version.
+func isVersionInGroup(version versionType, group string) bool {
+	name := strings.TrimPrefix(group, "v")
+	if _, err := fmt.Sscanf(name, "%d", new(int)); err != nil {
+		return true
+	}
+	return name == fmt.Sprintf("%d", version.Major) || name == fmt.Sprintf("%d.%d", version.Major, version.Minor)
+}
+
+func hasLintErrors(issues []lintIssueType) bool {
+	for _, issue := range issues {
+		if issue.Severity == lintSeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Lint the channels data on load. The data linted last time isn't linted again, so issues are logged
+// only when the data changes, whether or not it is published.
+func lintOnLoad(data []byte, filename string) (result lintResultType, err error) {
+	fragments := getChannelsFragments(data, filename)
+	checksum := sha256.Sum256(data)
+	if filename == mergedChannelsFileName {
+		// Positions in fragments can change without changes of the merged data
+		hash := sha256.New()
+		for _, fragment := range fragments {
+			hash.Write([]byte(fragment.Filename))
+			hash.Write([]byte{0})
+			hash.Write(fragment.Data)
+			hash.Write([]byte{0})
+		}
+		copy(checksum[:], hash.Sum(nil))
+	}
+
+	lintCache.Lock()
+	if checksum == lintCache.Checksum {
+		defer lintCache.Unlock()
+		return lintCache.lintResultType, nil
+	}
+	lintCache.Unlock()
+
+	issues, err := lintChannelsFragments(fragments, lintSeverityError)
+	if err != nil {
+		return result, err
+	}
+	for _, issue := range issues {
+		if issue.Severity == lintSeverityError {
+			log.Errorln(issue.String())
+		} else {
+			log.Warnln(issue.String())
+		}
+	}
+	result = lintResultType{Checksum: checksum, Issues: issues}
+
+	lintCache.Lock()
+	defer lintCache.Unlock()
+	lintCache.lintResultType = result
+	return result, nil
+}
+
+// Save the lint issues of the published channels data
+func updatePublishedLintIssues(result lintResultType) {
+	publishedLintIssues.Lock()
+	defer publishedLintIssues.Unlock()
+	publishedLintIssues.Issues = result.Issues
+}
+
+// Lint the channels data again on the next load, e.g. after the configuration is changed
+func resetLintCache() {
+	lintCache.Lock()
+	defer lintCache.Unlock()
+	lintCache.Checksum = [sha256.Size]byte{}
+}
+
+func getLintIssues() []lintIssueType {
+	publishedLintIssues.Lock()
+	defer publishedLintIssues.Unlock()
+	return publishedLintIssues.Issues
+}
+
+// The 'lint' command. Checks the specified channels files or the configured one.
+// A missing default group is an error only for the configured channels file, other files
+// can be meant for another default group. Returns the exit code.
+func lintCommand(args []string) int {
+	files := args
+	if len(files) == 0 {
+		files = []string{getConfig().PathChannelsFile}
+	}
+
+	exitCode := 0
+	for _, filename := range files {
+		var data []byte
+		var fragments []channelsFragmentType
+		var err error
+		switch {
+		case filename == getConfig().PathChannelsFile && isRemoteChannelsSource():
+			data, err = readChannelsData()
+		case isChannelsFragmentsSource(filename):
+			fragments, err = readChannelsFragments(filename)
+		default:
+			data, err = ioutil.ReadFile(filename)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			exitCode = 1
+			continue
+		}
+		if fragments == nil {
+			fragments = []channelsFragmentType{{Filename: filename, Data: data}}
+		}
+		missingDefaultGroup := lintSeverityWarning
+		if filename == getConfig().PathChannelsFile {
+			missingDefaultGroup = lintSeverityError
+		}
+		issues, err := lintChannelsFragments(fragments, missingDefaultGroup)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", filename, err.Error())
+			exitCode = 1
+			continue
+		}
+		for _, issue := range issues {
+			fmt.Println(issue.String())
+		}
+		if hasLintErrors(issues) {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"strconv"
+)
+
+// Get listeners for the main server.
+// Sockets passed by systemd are used if any, otherwise listen on the TCP address and/or the Unix socket.
+func getListeners() (listeners []net.Listener, err error) {
+	listeners, err = systemdListeners()
+	if err != nil || len(listeners) > 0 {
+		for _, listener := range listeners {
+			log.Infoln(fmt.Sprintf("Listening on %s (systemd socket activation)", listener.Addr()))
+		}
+		return
+	}
+
+	if getConfig().ListenPort != "" {
+		listener, err := net.Listen("tcp", fmt.Sprintf("%s:%s", getConfig().ListenAddress, getConfig().ListenPort))
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if getConfig().ListenSocket != "" {
+		listener, err := listenUnixSocket(getConfig().ListenSocket, getConfig().ListenSocketMode)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("nothing to listen on, specify the listen port or the Unix socket")
+	}
+	return
+}
+
+// Listen on the Unix socket with the specified permissions (e.g. '0660').
+// A stale socket file left after the previous run is removed.
+func listenUnixSocket(socketPath, mode string) (net.Listener, error) {
+	fileMode, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("wrong Unix socket mode %s (%s)", mode, err.Error())
+	}
+
+	if fi, err := os.Stat(socketPath); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socketPath, os.FileMode(fileMode)); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
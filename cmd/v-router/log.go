@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -15,10 +18,18 @@ type responseWriter struct {
 	header      string
 }
 
+type logDataContextKey struct{}
+
+// Data of the request set by handlers for the log, e.g. the chosen canary version.
+// It isn't sent to the client.
+type logDataType struct {
+	Canary string
+}
+
 // Setup logging level and format
 func Setup() {
 
-	switch GlobalConfig.LogFormat {
+	switch getConfig().LogFormat {
 	case "json":
 		log.SetFormatter(&log.JSONFormatter{DisableTimestamp: false})
 	case "text":
@@ -30,7 +41,7 @@ func Setup() {
 	}
 
 	var logLevel log.Level
-	switch strings.ToLower(GlobalConfig.LogLevel) {
+	switch strings.ToLower(getConfig().LogLevel) {
 	case "debug":
 		logLevel = log.DebugLevel
 	case "trace":
@@ -39,6 +50,24 @@ func Setup() {
 		logLevel = log.InfoLevel
 	}
 	log.SetLevel(logLevel)
+
+	// Product processes add the product name to every message
+	hooks := make(log.LevelHooks)
+	if getConfig().Product != "" {
+		hooks.Add(productLogHook{})
+	}
+	log.StandardLogger().ReplaceHooks(hooks)
+}
+
+type productLogHook struct{}
+
+func (productLogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (productLogHook) Fire(entry *log.Entry) error {
+	entry.Data["product"] = getConfig().Product
+	return nil
 }
 
 func wrapResponseWriter(w http.ResponseWriter) *responseWriter {
@@ -72,8 +101,10 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 
 		start := time.Now()
 		wrapped := wrapResponseWriter(w)
+		r = r.WithContext(context.WithValue(r.Context(), logDataContextKey{}, &logDataType{}))
 		next.ServeHTTP(wrapped, r)
 		logHTTPReq(wrapped, r, start)
+		observeHTTPReq(wrapped, r, start)
 	})
 }
 
@@ -103,9 +134,34 @@ func logHTTPReq(w *responseWriter, r *http.Request, startTime time.Time) {
 	if w.Header().Get("x-accel-redirect") != "" {
 		logentry += fmt.Sprintf(" x-redirect:%s", w.Header().Get("X-Accel-Redirect"))
 	}
+	if data := getLogData(r); data.Canary != "" {
+		logentry += fmt.Sprintf(" canary:%s", data.Canary)
+	}
 	log.Infoln(logentry)
 }
 
+// Get the log data of the request. Requests not passed through LoggingMiddleware get a throwaway one.
+func getLogData(r *http.Request) *logDataType {
+	if data, ok := r.Context().Value(logDataContextKey{}).(*logDataType); ok {
+		return data
+	}
+	return &logDataType{}
+}
+
+// Update request metrics
+func observeHTTPReq(w *responseWriter, r *http.Request, startTime time.Time) {
+	route := "none"
+	if currentRoute := mux.CurrentRoute(r); currentRoute != nil && currentRoute.GetName() != "" {
+		route = currentRoute.GetName()
+	}
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	addMetric("vrouter_http_requests_total", 1, "route", route, "code", strconv.Itoa(status))
+	observeMetric("vrouter_http_request_duration_seconds", time.Since(startTime).Seconds(), "route", route)
+}
+
 // Checks to skip logging some requests
 func skipHTTPRequestLogging(r *http.Request) bool {
 	switch r.URL.String() {
@@ -115,6 +171,8 @@ func skipHTTPRequestLogging(r *http.Request) bool {
 		return true
 	case "/health":
 		return true
+	case "/ready":
+		return true
 	}
 	return strings.HasPrefix(r.URL.String(), "/favicon-")
 }
@@ -4,15 +4,31 @@ import (
 	"context"
 	"fmt"
 	"github.com/gorilla/mux"
-	"github.com/kelseyhightower/envconfig"
 	log "github.com/sirupsen/logrus"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
-var GlobalConfig GlobalConfigType
+// The configuration, *GlobalConfigType. It's replaced as a whole on reload (see reloadConfig),
+// so readers never see a partially changed configuration.
+var globalConfig atomic.Value
+
+func getConfig() *GlobalConfigType {
+	if config, ok := globalConfig.Load().(*GlobalConfigType); ok {
+		return config
+	}
+	return &GlobalConfigType{}
+}
+
+func setConfig(config GlobalConfigType) {
+	globalConfig.Store(&config)
+}
 
 func newRouter() *mux.Router {
 	var langPrefix, channelList string
@@ -20,27 +36,38 @@ func newRouter() *mux.Router {
 
 	staticFileDirectory := http.Dir(getRootFilesPath())
 
-	if GlobalConfig.I18nType == "location" {
-		langPrefix = "/{lang:ru|en}"
+	if getConfig().I18nType == "location" {
+		langPrefix = fmt.Sprintf("/{lang:%s}", getLangPattern())
 	}
 
-    channelList = "alpha|beta|ea|early-access|stable|rock-solid"
-	if GlobalConfig.UseLatestChannel {
+	channelList = strings.Join(getConfig().Channels, "|")
+	if isKnownChannel("ea") {
+		channelList += "|early-access"
+	}
+	if getConfig().UseLatestChannel {
 		channelList = "latest|" + channelList
 	}
 
-	r.PathPrefix("/status").HandlerFunc(statusHandler)
-	r.PathPrefix("/health").HandlerFunc(healthCheckHandler)
+	// Service endpoints are moved to the admin listener if it is enabled
+	if !isAdminListenerEnabled() {
+		r.PathPrefix("/status").HandlerFunc(statusHandler).Name("status")
+		r.PathPrefix("/health").HandlerFunc(healthCheckHandler).Name("health")
+		r.PathPrefix("/ready").HandlerFunc(readinessHandler).Name("ready")
+	}
 
-	r.PathPrefix(fmt.Sprintf("%s%s/{group:v[0-9]+.[0-9]+}-{channel:%s}/", langPrefix, GlobalConfig.LocationVersions, channelList)).HandlerFunc(groupChannelHandler)
-	r.PathPrefix(fmt.Sprintf("%s%s/{group:v[0-9]+}-{channel:%s}/", langPrefix, GlobalConfig.LocationVersions, channelList)).HandlerFunc(groupChannelHandler)
-	r.PathPrefix(fmt.Sprintf("%s%s/{group:v[0-9]+}/", langPrefix, GlobalConfig.LocationVersions)).HandlerFunc(groupHandler)
-	r.PathPrefix(fmt.Sprintf("%s%s/", langPrefix, GlobalConfig.LocationVersions)).HandlerFunc(rootDocHandler)
-	r.PathPrefix(fmt.Sprintf("%s%s/", langPrefix, GlobalConfig.PathTpls)).HandlerFunc(templateHandler)
+	r.PathPrefix(fmt.Sprintf("%s%s/{group:v[0-9]+.[0-9]+}-{channel:%s}/", langPrefix, getConfig().LocationVersions, channelList)).HandlerFunc(groupChannelHandler).Name("groupMinorChannel")
+	r.PathPrefix(fmt.Sprintf("%s%s/{group:v[0-9]+}-{channel:%s}/", langPrefix, getConfig().LocationVersions, channelList)).HandlerFunc(groupChannelHandler).Name("groupChannel")
+	r.PathPrefix(fmt.Sprintf("%s%s/{group:v[0-9]+}/", langPrefix, getConfig().LocationVersions)).HandlerFunc(groupHandler).Name("group")
+	r.PathPrefix(fmt.Sprintf("%s%s/{version:%s}/", langPrefix, getConfig().LocationVersions, versionRangeURLPattern)).HandlerFunc(versionRangeHandler).Name("versionRange")
+	// Exact versions are served by rootDocHandler, unless banners or the retention policy are enabled
+	r.PathPrefix(fmt.Sprintf("%s%s/{version:v[0-9]+\\.[0-9]+\\.[0-9]+[^/]*}/", langPrefix, getConfig().LocationVersions)).MatcherFunc(isVersionRouteEnabled).Handler(versionHandler(bannerMiddleware(serveFilesHandler(staticFileDirectory), getRequestPagePath))).Name("version")
+	r.PathPrefix(fmt.Sprintf("%s%s/", langPrefix, getConfig().LocationVersions)).HandlerFunc(rootDocHandler).Name("rootDoc")
+	r.Path(fmt.Sprintf("%s%s/menu.json", langPrefix, getConfig().PathTpls)).HandlerFunc(menuHandler).Name("menu")
+	r.PathPrefix(fmt.Sprintf("%s%s/", langPrefix, getConfig().PathTpls)).HandlerFunc(templateHandler).Name("template")
 
-	r.Path("/404.html").HandlerFunc(notFoundHandler)
+	r.Path("/404.html").HandlerFunc(notFoundHandler).Name("notFound")
 
-	r.PathPrefix("/").Handler(serveFilesHandler(staticFileDirectory))
+	r.PathPrefix("/").Handler(serveFilesHandler(staticFileDirectory)).Name("static")
 
 	r.Use(LoggingMiddleware)
 
@@ -50,42 +77,175 @@ func newRouter() *mux.Router {
 }
 
 func main() {
-	err := envconfig.Process("VROUTER", &GlobalConfig)
+	config, err := loadConfig()
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+	setConfig(config)
 
 	Setup()
+
+	command, args := "serve", []string{}
+	if len(os.Args) > 1 {
+		command, args = os.Args[1], os.Args[2:]
+	}
+	for _, item := range commands {
+		if item.Name == command {
+			os.Exit(item.Run(args))
+		}
+	}
+	printUsage()
+	os.Exit(2)
+}
+
+// The 'serve' command. Starts the server and waits for a termination signal.
+func serveCommand(args []string) int {
 	ValidateConfig()
 	printConfiguration()
 
-	r := newRouter()
+	var r *mux.Router
+	if isProductsSupervisor() {
+		if err := startProducts(); err != nil {
+			log.Fatal(err.Error())
+		}
+		r = newProductsRouter()
+	} else {
+		if err := loadHistory(); err != nil {
+			log.Errorln(err.Error())
+		}
+		// The channels data is loaded before serving requests, then in the background
+		if err := updateReleasesStatus(); err != nil {
+			log.Errorln(err.Error())
+		}
+		go runConsistencyChecker()
+		go runChannelsSourcePoller()
+		r = newRouter()
+	}
 
 	srv := &http.Server{
-		Handler:      r,
-		Addr:         fmt.Sprintf("%s:%s", GlobalConfig.ListenAddress, GlobalConfig.ListenPort),
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Handler:           r,
+		Addr:              fmt.Sprintf("%s:%s", getConfig().ListenAddress, getConfig().ListenPort),
+		WriteTimeout:      getConfig().WriteTimeout,
+		ReadTimeout:       getConfig().ReadTimeout,
+		ReadHeaderTimeout: getConfig().ReadHeaderTimeout,
+		IdleTimeout:       getConfig().IdleTimeout,
 	}
+	servers := []*http.Server{srv}
 
-	go func() {
-		err := srv.ListenAndServe()
-		if err == http.ErrServerClosed {
-			err = nil
-		}
+	if isTLSEnabled() {
+		reloader, err := newCertReloader(getConfig().TlsCertFile, getConfig().TlsKeyFile)
 		if err != nil {
-			log.Errorln(err)
+			log.Fatal(err.Error())
+		}
+		go reloader.watch(getConfig().TlsReloadInterval)
+		srv.TLSConfig = newTLSConfig(reloader)
+	}
+
+	listeners, err := getListeners()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			var err error
+			if isTLSEnabled() {
+				err = srv.ServeTLS(listener, "", "")
+			} else {
+				err = srv.Serve(listener)
+			}
+			if err == http.ErrServerClosed {
+				err = nil
+			}
+			if err != nil {
+				log.Errorln(err)
+			}
+		}(listener)
+	}
+
+	if getConfig().HttpRedirectPort != "" {
+		redirectSrv := &http.Server{
+			Handler:           http.HandlerFunc(httpsRedirectHandler),
+			Addr:              fmt.Sprintf("%s:%s", getConfig().ListenAddress, getConfig().HttpRedirectPort),
+			WriteTimeout:      getConfig().WriteTimeout,
+			ReadTimeout:       getConfig().ReadTimeout,
+			ReadHeaderTimeout: getConfig().ReadHeaderTimeout,
+			IdleTimeout:       getConfig().IdleTimeout,
 		}
-	}()
+		servers = append(servers, redirectSrv)
+		go func() {
+			err := redirectSrv.ListenAndServe()
+			if err == http.ErrServerClosed {
+				err = nil
+			}
+			if err != nil {
+				log.Errorln(err)
+			}
+		}()
+	}
+
+	if isAdminListenerEnabled() {
+		// No write timeout, CPU profiling and tracing take longer
+		adminSrv := &http.Server{
+			Handler:           newAdminRouter(),
+			Addr:              fmt.Sprintf("%s:%s", getConfig().AdminListenAddress, getConfig().AdminListenPort),
+			ReadTimeout:       getConfig().ReadTimeout,
+			ReadHeaderTimeout: getConfig().ReadHeaderTimeout,
+			IdleTimeout:       getConfig().IdleTimeout,
+		}
+		servers = append(servers, adminSrv)
+		go func() {
+			var err error
+			if getConfig().AdminListenSocket != "" {
+				var listener net.Listener
+				if listener, err = listenUnixSocket(getConfig().AdminListenSocket, "0600"); err == nil {
+					err = adminSrv.Serve(listener)
+				}
+			} else {
+				err = adminSrv.ListenAndServe()
+			}
+			if err == http.ErrServerClosed {
+				err = nil
+			}
+			if err != nil {
+				log.Errorln(err)
+			}
+		}()
+	}
+
+	if err := sdNotify("READY=1"); err != nil {
+		log.Errorln(fmt.Sprintf("Can't notify systemd (%s)", err.Error()))
+	}
+	go runSystemdWatchdog()
+	go runConfigReloader()
 
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	sig := <-c
+	log.Infoln(fmt.Sprintf("Got %s signal", sig))
+
+	_ = sdNotify("STOPPING=1")
+
+	// Fail readiness probes and give balancers time to stop sending new requests
+	setShuttingDown()
+	if getConfig().DrainDelay > 0 {
+		log.Infoln(fmt.Sprintf("Draining for %s...", getConfig().DrainDelay))
+		time.Sleep(getConfig().DrainDelay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), getConfig().ShutdownTimeout)
 	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("shutdown failed:%+s", err)
+	// Everything is stopped even if something fails to stop
+	exitCode := 0
+	for _, item := range servers {
+		if err := item.Shutdown(ctx); err != nil {
+			log.Errorln(fmt.Sprintf("Shutdown failed (%s)", err.Error()))
+			exitCode = 1
+		}
+	}
+	if err := stopProducts(ctx); err != nil {
+		log.Errorln(err.Error())
+		exitCode = 1
 	}
 	log.Infoln("Shutting down...")
+	return exitCode
 }
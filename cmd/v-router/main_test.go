@@ -1,13 +1,46 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/kelseyhightower/envconfig"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
+func TestMain(m *testing.M) {
+	var config GlobalConfigType
+	if err := envconfig.Process("VROUTER", &config); err != nil {
+		panic(err)
+	}
+	config.PathChannelsFile = "testdata/channels.yaml"
+	config.PathStatic = "testdata/root"
+	config.I18nType = "location"
+	setConfig(config)
+	_ = updateReleasesStatus()
+	os.Exit(m.Run())
+}
+
+// Change a copy of the configuration and publish it, as the configuration reload does,
+// to not race with readers of the configuration. Returns the function restoring the previous configuration.
+func changeTestConfig(change func(config *GlobalConfigType)) (restore func()) {
+	previous := *getConfig()
+	config := previous
+	change(&config)
+	setConfig(config)
+	return func() { setConfig(previous) }
+}
+
 func TestHandler(t *testing.T) {
-	req, err := http.NewRequest("GET", "/", nil)
+	req, err := http.NewRequest("GET", getConfig().PathTpls+"/version-menu.html", nil)
 
 	if err != nil {
 		t.Fatal(err)
@@ -54,3 +87,655 @@ func TestStaticFileServer(t *testing.T) {
 	}
 
 }
+
+func TestVersionRangeHandler(t *testing.T) {
+	r := newRouter()
+
+	tests := map[string]string{
+		"/en/documentation/v1.2.x/":           "/en/documentation/v1.2.3-plus-fix10/",
+		"/ru/documentation/v1.2.x/":           "/ru/documentation/v1.2.4/",
+		"/en/documentation/~1.2/install.html": "/en/documentation/v1.2.3-plus-fix10/install.html",
+		"/en/documentation/1.2.3/":            "/en/documentation/v1.2.3-plus-fix10/",
+		"/en/documentation/1.x/":              "/en/documentation/v1.3.0/",
+	}
+
+	for uri, expected := range tests {
+		req := httptest.NewRequest("GET", uri, nil)
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, req)
+		if actual := recorder.Header().Get("X-Accel-Redirect"); actual != expected {
+			t.Errorf("%s: wrong X-Accel-Redirect, expected %s, got %s", uri, expected, actual)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/en/documentation/2.x/", nil)
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Status should be 404 for unknown version range, got %d", recorder.Code)
+	}
+
+	// Versions of the channels file are used without version directories
+	defer changeTestConfig(func(config *GlobalConfigType) { config.PathStatic = t.TempDir() })()
+	req = httptest.NewRequest("GET", "/en/documentation/1.2/", nil)
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	if actual := recorder.Header().Get("X-Accel-Redirect"); actual != "/en/documentation/v1.2.4/" {
+		t.Errorf("Wrong X-Accel-Redirect without version directories %s", actual)
+	}
+}
+
+func TestVersionsDiscovery(t *testing.T) {
+	defer changeTestConfig(func(config *GlobalConfigType) {
+		config.VersionsDiscovery = true
+		config.PathStatic = "testdata/discovery"
+	})()
+
+	if err := updateReleasesStatus(); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := getVersionFromGroup(getReleasesStatus(), "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "v2.0.1" {
+		t.Errorf("Wrong version for the discovered group, expected v2.0.1, got %s", version)
+	}
+
+	data := templateDataType{}
+	_ = data.getChannelsFromGroup(getReleasesStatus(), "v2")
+	if len(data.VersionItems) != 1 || data.VersionItems[0].VersionURL != "v2.0.1" {
+		t.Errorf("Discovered version is not in the menu: %+v", data.VersionItems)
+	}
+}
+
+func TestChannelsConsistency(t *testing.T) {
+	if err := updateReleasesStatus(); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := make(map[string]bool)
+	for _, item := range checkChannelsConsistency(getReleasesStatus()) {
+		missing[item.Lang+":"+item.Version] = true
+	}
+	if !missing["en:v1.2.4"] || !missing["ru:v1.3.0"] {
+		t.Errorf("Missing versions are not reported: %v", missing)
+	}
+	if missing["en:v1.3.0"] || missing["ru:v1.2.4"] {
+		t.Errorf("Existing versions are reported as missing: %v", missing)
+	}
+
+	defer changeTestConfig(func(config *GlobalConfigType) { config.RejectInconsistentChannels = true })()
+	if err := updateReleasesStatus(); err == nil {
+		t.Errorf("Inconsistent channels file should be rejected")
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	var result readinessResponseType
+
+	_ = updateReleasesStatus()
+	recorder := httptest.NewRecorder()
+	readinessHandler(recorder, httptest.NewRequest("GET", "/ready", nil))
+
+	if err := json.NewDecoder(recorder.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	for _, check := range []string{"channels", "static", "templates", "defaultGroup"} {
+		if result.Checks[check].Status != "ok" {
+			t.Errorf("Check %s should pass, got %+v", check, result.Checks[check])
+		}
+	}
+	if _, ok := result.Checks["consistency"]; ok {
+		t.Errorf("Consistency should not be a readiness check")
+	}
+
+	defer changeTestConfig(func(config *GlobalConfigType) { config.DefaultGroup = "v10" })()
+	recorder = httptest.NewRecorder()
+	readinessHandler(recorder, httptest.NewRequest("GET", "/ready", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Status should be 503 for unknown default group, got %d", recorder.Code)
+	}
+}
+
+func TestChannelsSchedule(t *testing.T) {
+	data := []byte(`groups:
+ - name: "v1"
+   channels:
+    - name: stable
+      version: v1.2.3+fix6
+      schedule:
+       - version: v1.2.3+fix10
+         activateAt: 2020-01-01T10:00:00Z
+       - version: v1.2.4
+         activateAt: 2100-01-01T10:00:00Z
+    - name: beta
+      version: v1.3.0
+      activateAt: 2100-01-01T12:00:00Z
+    - name: beta
+      version: v1.2.4
+`)
+
+	releases, err := loadReleasesStatus(data, "channels.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version, _ := getVersionFromChannelAndGroup(&releases, "stable", "v1"); version != "v1.2.3+fix10" {
+		t.Errorf("Wrong stable version, expected v1.2.3+fix10, got %s", version)
+	}
+	if version, _ := getVersionFromChannelAndGroup(&releases, "beta", "v1"); version != "v1.2.4" {
+		t.Errorf("Wrong beta version, expected v1.2.4, got %s", version)
+	}
+	if len(releases.Upcoming) != 2 || releases.Upcoming[0].Version != "v1.2.4" || releases.Upcoming[1].Version != "v1.3.0" {
+		t.Errorf("Wrong upcoming changes: %+v", releases.Upcoming)
+	}
+}
+
+func TestLintChannelsSchedule(t *testing.T) {
+	data := []byte(`groups:
+ - name: "v1"
+   channels:
+    - name: stable
+      version: v1.2.3
+      activateAt: 2021-06-01T10:00:00Z
+      schedule:
+       - version: v1.2.4
+         activateAt: 2021-05-01T10:00:00Z
+       - version: v1.2.5
+         activateAt: 2021-07-01T10:00:00Z
+`)
+
+	issues, err := lintChannelsFile(data, "channels.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "channels.yaml:9:22: error: schedule item of channel stable in group v1 is activated at 2021-05-01T10:00:00Z, not after the entry itself, it is ignored"
+	if len(issues) != 1 || issues[0].String() != expected {
+		t.Errorf("Expected issue '%s', got %v", expected, issues)
+	}
+}
+
+func TestLintChannelsFile(t *testing.T) {
+	data := []byte(`groups:
+ - name: "v1"
+   channels:
+    - name: beta
+      version: 1.1.0
+    - name: stable
+      version: 1.2.0
+    - name: stable
+      version: 1.2.1
+    - name: nightly
+      version: 1.3.0
+ - name: "v2"
+   channels:
+    - name: alpha
+      version: 1.2.4
+`)
+
+	issues, err := lintChannelsFile(data, "channels.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"channels.yaml:8:13: error: channel stable is defined more than once in group v1",
+		"channels.yaml:10:13: error: unknown channel nightly in group v1",
+		"channels.yaml:5:16: warning: channel beta has version 1.1.0 older than version 1.2.0 of the more stable channel stable in group v1",
+		"channels.yaml:15:16: warning: version 1.2.4 doesn't belong to group v2",
+	}
+	if len(issues) != len(expected) {
+		t.Fatalf("Expected %d issues, got %d: %v", len(expected), len(issues), issues)
+	}
+	for i, issue := range issues {
+		if issue.String() != expected[i] {
+			t.Errorf("Wrong issue, expected '%s', got '%s'", expected[i], issue.String())
+		}
+	}
+
+	// Only issues of the published data are shown
+	if err := updateReleasesStatus(); err != nil {
+		t.Fatal(err)
+	}
+	defer changeTestConfig(func(config *GlobalConfigType) { config.RejectInvalidChannels = true })()
+	if _, err := loadReleasesStatus(data, "channels.yaml"); err == nil {
+		t.Errorf("Channels data with errors should be rejected")
+	}
+	if issues := getLintIssues(); len(issues) != 0 {
+		t.Errorf("Issues of the rejected data are shown: %v", issues)
+	}
+	// The rejected data isn't linted again while it doesn't change
+	lintCache.Lock()
+	checksum := lintCache.Checksum
+	lintCache.Unlock()
+	if checksum != sha256.Sum256(data) {
+		t.Errorf("The lint result of the rejected data isn't cached")
+	}
+}
+
+func TestVersionMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "v-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := []byte(`groups:
+ - name: "v1"
+   channels:
+    - name: stable
+      version: v1.2.3+fix6
+      releaseDate: 2021-03-01
+      eolDate: 2022-03-01
+      deprecated: true
+      gitRef: v1.2.3+fix6
+    - name: alpha
+      version: v1.3.0
+      releaseNotesURL: https://example.com/v1.3.0
+      schedule:
+       - version: v1.3.1
+         activateAt: 2100-01-01T00:00:00Z
+         releaseDate: 2100-01-01
+`)
+	filename := filepath.Join(dir, "channels.yaml")
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	restoreConfig := changeTestConfig(func(config *GlobalConfigType) { config.PathChannelsFile = filename })
+	defer func() {
+		restoreConfig()
+		_ = updateReleasesStatus()
+	}()
+	_ = updateReleasesStatus()
+
+	req := httptest.NewRequest("GET", "/en"+getConfig().PathTpls+"/menu.json", nil)
+	req.Header.Set("x-original-uri", "/en/documentation/v1.2.3-plus-fix6/install.html")
+	recorder := httptest.NewRecorder()
+	newRouter().ServeHTTP(recorder, req)
+
+	var menu templateDataType
+	if err := json.Unmarshal(recorder.Body.Bytes(), &menu); err != nil {
+		t.Fatal(err)
+	}
+	items := make(map[string]versionMenuItems)
+	for _, item := range menu.VersionItems {
+		items[item.Channel] = item
+	}
+	stable := items["stable"]
+	if stable.ReleaseDate != "2021-03-01" || !stable.IsEOL || !stable.Deprecated || stable.GitRef != "v1.2.3+fix6" {
+		t.Errorf("Wrong stable menu item %+v", stable)
+	}
+	// The schedule item isn't active yet, the channel keeps the metadata of its version
+	if alpha := items["alpha"]; alpha.Version != "v1.3.0" || alpha.ReleaseNotesURL != "https://example.com/v1.3.0" || alpha.IsEOL {
+		t.Errorf("Wrong alpha menu item %+v", alpha)
+	}
+	// The current version is the first item
+	if menu.VersionItems[0].Version != "v1.2.3+fix6" || menu.VersionItems[0].EOLDate != "2022-03-01" {
+		t.Errorf("Wrong current menu item %+v", menu.VersionItems[0])
+	}
+
+	issues, err := lintChannelsFile([]byte("groups:\n - name: v1\n   channels:\n    - name: stable\n      version: v1.2.3\n      eolDate: 01.03.2022\n"), "channels.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 || issues[0].String() != "channels.yaml:6:16: error: can't parse eolDate 01.03.2022, it should be YYYY-MM-DD" {
+		t.Errorf("Wrong issues %v", issues)
+	}
+}
+
+func TestResolveRequest(t *testing.T) {
+	if err := updateReleasesStatus(); err != nil {
+		t.Fatal(err)
+	}
+	result, err := resolveRequest(newRouter(), "/en/documentation/v1/install.html", "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Route != "group" || result.Vars["group"] != "v1" {
+		t.Errorf("Wrong route %s with vars %v", result.Route, result.Vars)
+	}
+	if result.AccelRedirect != "/en/documentation/v1.2.3-plus-fix6/install.html" || result.Version != "v1.2.3+fix6" || result.PageURLRelative != "install.html" {
+		t.Errorf("Wrong resolution: X-Accel-Redirect %s, version %s", result.AccelRedirect, result.Version)
+	}
+
+	result, err = resolveRequest(newRouter(), "/en/documentation/v1-beta/", "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != http.StatusFound || result.Version != "v1.2.4" || result.Validation != validationOK {
+		t.Errorf("Wrong resolution: status %d, version %s, validation %s", result.Status, result.Version, result.Validation)
+	}
+
+	// Target URLs are not requested
+	restoreConfig := changeTestConfig(func(config *GlobalConfigType) { config.UrlValidation = true })
+	result, err = resolveRequest(newRouter(), "/en/documentation/v1-beta/", "localhost:1")
+	restoreConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != http.StatusFound || result.Validation != validationSkipped {
+		t.Errorf("Wrong resolution: status %d, validation %s", result.Status, result.Validation)
+	}
+
+	recorder := httptest.NewRecorder()
+	debugResolveHandler(recorder, httptest.NewRequest("GET", "/debug/resolve?uri=/en/documentation/v1.2-ea/", nil))
+	var response map[string]interface{}
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response["route"] != "groupMinorChannel" || response["status"] != float64(http.StatusNotFound) {
+		t.Errorf("Wrong debug resolve response %v", response)
+	}
+
+	// The request is resolved for the host parameter
+	recorder = httptest.NewRecorder()
+	debugResolveHandler(recorder, httptest.NewRequest("GET", "/debug/resolve?uri=/en/documentation/v1/&host=docs.example.com", nil))
+	response = nil
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response["host"] != "docs.example.com" {
+		t.Errorf("Wrong host of the debug resolve response %v", response["host"])
+	}
+
+	recorder = httptest.NewRecorder()
+	debugResolveHandler(recorder, httptest.NewRequest("GET", "/debug/resolve?uri=/%25zz", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a malformed uri, got %d", recorder.Code)
+	}
+}
+
+func TestBanner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "v-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"channels.yaml": "groups:\n - name: v1\n   channels:\n    - name: rock-solid\n      version: v1.1.0\n    - name: stable\n      version: v1.2.3\n - name: v2\n   channels:\n    - name: stable\n      version: v2.0.1\n",
+		"root/includes/banner.html":                  `<div class="banner">{{ .Version }} is {{ .Reason }}, see <a href="{{ .StableURL }}">{{ .StableVersion }}</a></div>`,
+		"root/en/documentation/v1.1.0/install.html":  "<html><body class=\"doc\"><p>v1.1.0</p></body></html>",
+		"root/en/documentation/v1.1.0/old.html":      "<html><body><p>v1.1.0</p></body></html>",
+		"root/en/documentation/v1.2.3/install.html":  "<html><body><p>v1.2.3</p></body></html>",
+		"root/en/documentation/v1.1.0/channels.json": "{}",
+		"root/en/documentation/v2.0.0/install.html":  "<html><body><p>v2.0.0</p></body></html>",
+		"root/en/documentation/v2.0.1/install.html":  "<html><body><p>v2.0.1</p></body></html>",
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(config GlobalConfigType) {
+		setConfig(config)
+		_ = updateReleasesStatus()
+	}(*getConfig())
+	changeTestConfig(func(config *GlobalConfigType) {
+		config.PathChannelsFile = filepath.Join(dir, "channels.yaml")
+		config.PathStatic = filepath.Join(dir, "root")
+		config.BannerTemplate = "banner.html"
+	})
+	_ = updateReleasesStatus()
+
+	get := func(uri string) string {
+		recorder := httptest.NewRecorder()
+		newRouter().ServeHTTP(recorder, httptest.NewRequest("GET", uri, nil))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("Wrong status %d of %s", recorder.Code, uri)
+		}
+		return recorder.Body.String()
+	}
+
+	expected := `<html><body class="doc"><div class="banner">v1.1.0 is outdated, see <a href="/en/documentation/v1.2.3/install.html">v1.2.3</a></div><p>v1.1.0</p></body></html>`
+	if body := get("/en/documentation/v1.1.0/install.html"); body != expected {
+		t.Errorf("Wrong page with banner %s", body)
+	}
+	// Versions of other groups link to the stable version of their group
+	if body := get("/en/documentation/v2.0.0/install.html"); !strings.Contains(body, `href="/en/documentation/v2.0.1/install.html">v2.0.1`) {
+		t.Errorf("Wrong link to the stable version of the group %s", body)
+	}
+	// There is no such page in the stable version
+	if body := get("/en/documentation/v1.1.0/old.html"); !strings.Contains(body, `href="/en/documentation/v1.2.3/"`) {
+		t.Errorf("Wrong link to the stable version %s", body)
+	}
+	if body := get("/en/documentation/v1.2.3/install.html"); body != files["root/en/documentation/v1.2.3/install.html"] {
+		t.Errorf("Page of the stable version has a banner %s", body)
+	}
+	if body := get("/en/documentation/v1.1.0/channels.json"); body != "{}" {
+		t.Errorf("Not an HTML page has a banner %s", body)
+	}
+
+	// Other files are served as is, e.g. ranges
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/en/documentation/v1.1.0/channels.json", nil)
+	req.Header.Set("Range", "bytes=0-0")
+	newRouter().ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusPartialContent || recorder.Body.String() != "{" {
+		t.Errorf("Wrong response to a range request: status %d, body %s", recorder.Code, recorder.Body.String())
+	}
+
+	// Exact versions are served by rootDocHandler without banners
+	changeTestConfig(func(config *GlobalConfigType) { config.BannerTemplate = "" })
+	var match mux.RouteMatch
+	if newRouter().Match(httptest.NewRequest("GET", "/en/documentation/v1.1.0/install.html", nil), &match); match.Route == nil || match.Route.GetName() != "rootDoc" {
+		t.Errorf("Exact version should be routed to rootDoc without banners")
+	}
+}
+
+func TestRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "v-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"channels.yaml":                             "groups:\n - name: v1\n   channels:\n    - name: stable\n      version: v1.2.3\n",
+		"root/includes/.keep":                       "",
+		"root/en/documentation/v1.0.0/gone.html":    "v1.0.0",
+		"root/en/documentation/v1.0.1/install.html": "v1.0.1",
+		"root/en/documentation/v1.1.0/install.html": "v1.1.0",
+		"root/en/documentation/v1.2.3/install.html": "v1.2.3",
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(config GlobalConfigType) {
+		setConfig(config)
+		_ = updateReleasesStatus()
+	}(*getConfig())
+	changeTestConfig(func(config *GlobalConfigType) {
+		config.PathChannelsFile = filepath.Join(dir, "channels.yaml")
+		config.PathStatic = filepath.Join(dir, "root")
+		config.RetentionKeepPatches = 2
+	})
+	_ = updateReleasesStatus()
+
+	if retired := getRetentionStatus().Retired; strings.Join(retired, ",") != "v1.0.0,v1.0.1" {
+		t.Errorf("Wrong retired versions %v", retired)
+	}
+
+	tests := []struct {
+		uri      string
+		status   int
+		location string
+	}{
+		{"/en/documentation/v1.0.1/install.html", http.StatusMovedPermanently, "/en/documentation/v1.2.3/install.html"},
+		{"/en/documentation/v1.0.0/gone.html", http.StatusMovedPermanently, "/en/documentation/v1.2.3/"},
+		{"/en/documentation/v1.1.0/install.html", http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		newRouter().ServeHTTP(recorder, httptest.NewRequest("GET", test.uri, nil))
+		if recorder.Code != test.status || recorder.Header().Get("Location") != test.location {
+			t.Errorf("Wrong response to %s: status %d, location %s", test.uri, recorder.Code, recorder.Header().Get("Location"))
+		}
+	}
+
+	// Versions released since the date are kept
+	changeTestConfig(func(config *GlobalConfigType) { config.RetentionKeepSince = "2000-01-01" })
+	updateRetiredVersions(getReleasesStatus())
+	if retired := getRetentionStatus().Retired; len(retired) != 0 {
+		t.Errorf("Wrong retired versions %v", retired)
+	}
+}
+
+func TestChannelOverride(t *testing.T) {
+	defer changeTestConfig(func(config *GlobalConfigType) { config.ChannelOverrideChannels = []string{"beta"} })()
+
+	tests := []struct {
+		name   string
+		uri    string
+		header string
+		cookie string
+		target string
+		sticky string
+		status int
+	}{
+		{name: "query", uri: "/en/documentation/v1/install.html?channel=beta", target: "/en/documentation/v1.2.4/install.html", sticky: "beta", status: http.StatusOK},
+		{name: "header", uri: "/en/documentation/v1/install.html", header: "beta", target: "/en/documentation/v1.2.4/install.html", status: http.StatusOK},
+		{name: "cookie", uri: "/en/documentation/v1/install.html", cookie: "beta", target: "/en/documentation/v1.2.4/install.html", status: http.StatusOK},
+		{name: "not allowed", uri: "/en/documentation/v1/install.html?channel=alpha", target: "/en/documentation/v1.2.3-plus-fix6/install.html", status: http.StatusOK},
+		{name: "root", uri: "/en/documentation/install.html?channel=beta", sticky: "beta", status: http.StatusFound},
+		{name: "root without channel", uri: "/en/documentation/install.html", status: http.StatusMovedPermanently},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.uri, nil)
+		if test.header != "" {
+			req.Header.Set("X-Vrouter-Channel", test.header)
+		}
+		if test.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "vrouter_channel", Value: test.cookie})
+		}
+		recorder := httptest.NewRecorder()
+		newRouter().ServeHTTP(recorder, req)
+
+		if recorder.Code != test.status || recorder.Header().Get("X-Accel-Redirect") != test.target {
+			t.Errorf("%s: wrong status %d, X-Accel-Redirect %s", test.name, recorder.Code, recorder.Header().Get("X-Accel-Redirect"))
+		}
+		var sticky string
+		for _, cookie := range recorder.Result().Cookies() {
+			if cookie.Name == "vrouter_channel" {
+				sticky = cookie.Value
+			}
+		}
+		if sticky != test.sticky {
+			t.Errorf("%s: wrong sticky channel %s", test.name, sticky)
+		}
+		if vary := strings.Join(recorder.Header()["Vary"], ","); vary != "X-Vrouter-Channel,Cookie" {
+			t.Errorf("%s: wrong Vary %s", test.name, vary)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/en"+getConfig().PathTpls+"/menu.json", nil)
+	req.Header.Set("x-original-uri", "/en/documentation/v1/install.html?channel=beta")
+	recorder := httptest.NewRecorder()
+	newRouter().ServeHTTP(recorder, req)
+	var menu templateDataType
+	if err := json.Unmarshal(recorder.Body.Bytes(), &menu); err != nil {
+		t.Fatal(err)
+	}
+	if menu.ChannelOverride != "beta" || menu.CurrentChannel != "beta" || menu.AbsoluteVersion != "v1.2.4" {
+		t.Errorf("Wrong menu data: override %s, channel %s, absolute version %s", menu.ChannelOverride, menu.CurrentChannel, menu.AbsoluteVersion)
+	}
+}
+
+func TestCanary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "v-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := []byte(`groups:
+ - name: "v1"
+   channels:
+    - name: stable
+      version: v1.2.3
+      canary:
+       - version: v1.2.4
+         weight: 10
+`)
+	filename := filepath.Join(dir, "channels.yaml")
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	restoreConfig := changeTestConfig(func(config *GlobalConfigType) { config.PathChannelsFile = filename })
+	defer func() {
+		restoreConfig()
+		_ = updateReleasesStatus()
+	}()
+	_ = updateReleasesStatus()
+
+	tests := []struct {
+		bucket  string
+		version string
+		canary  string
+	}{
+		{"5", "v1.2.4", "v1.2.4"},
+		{"50", "v1.2.3", ""},
+	}
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/en/documentation/v1/install.html", nil)
+		req.AddCookie(&http.Cookie{Name: "vrouter_canary", Value: test.bucket})
+		recorder := httptest.NewRecorder()
+		logs.Reset()
+		newRouter().ServeHTTP(recorder, req)
+		if target := recorder.Header().Get("X-Accel-Redirect"); target != "/en/documentation/"+test.version+"/install.html" {
+			t.Errorf("Bucket %s: wrong X-Accel-Redirect %s", test.bucket, target)
+		}
+		// The canary version is logged, but not sent to the client
+		if logged := strings.Contains(logs.String(), " canary:"+test.version); logged != (test.canary != "") || len(recorder.Header().Values("X-Vrouter-Canary")) > 0 {
+			t.Errorf("Bucket %s: wrong canary in the log %q or in the response headers %v", test.bucket, logs.String(), recorder.Header())
+		}
+		// Responses differ by the bucket and must not be shared by caches
+		if recorder.Header().Get("Vary") != "Cookie" || recorder.Header().Get("Cache-Control") != "private" {
+			t.Errorf("Bucket %s: wrong Vary %s, Cache-Control %s", test.bucket, recorder.Header().Get("Vary"), recorder.Header().Get("Cache-Control"))
+		}
+
+		// The menu shows the same version
+		req = httptest.NewRequest("GET", "/en"+getConfig().PathTpls+"/menu.json", nil)
+		req.Header.Set("x-original-uri", "/en/documentation/v1/install.html")
+		req.AddCookie(&http.Cookie{Name: "vrouter_canary", Value: test.bucket})
+		recorder = httptest.NewRecorder()
+		newRouter().ServeHTTP(recorder, req)
+		var menu templateDataType
+		if err := json.Unmarshal(recorder.Body.Bytes(), &menu); err != nil {
+			t.Fatal(err)
+		}
+		if menu.AbsoluteVersion != test.version || len(menu.VersionItems) < 2 || menu.VersionItems[1].Version != test.version {
+			t.Errorf("Bucket %s: wrong menu absolute version %s, items %+v", test.bucket, menu.AbsoluteVersion, menu.VersionItems)
+		}
+	}
+
+	// The bucket of a new client is saved
+	recorder := httptest.NewRecorder()
+	newRouter().ServeHTTP(recorder, httptest.NewRequest("GET", "/en/documentation/v1/", nil))
+	if cookies := recorder.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != "vrouter_canary" {
+		t.Errorf("Wrong cookies %v", cookies)
+	}
+
+	issues, err := lintChannelsFile([]byte("groups:\n - name: v1\n   channels:\n    - name: stable\n      version: v1.2.3\n      canary:\n       - version: v1.2.4\n         weight: 60\n       - version: v1.2.5\n         weight: 40\n"), "channels.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 || issues[0].String() != "channels.yaml:7:8: error: total canary weight 100 should be less than 100" {
+		t.Errorf("Wrong issues %v", issues)
+	}
+}
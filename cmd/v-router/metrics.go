@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Minimal metrics registry exposed in the Prometheus text format
+type metricType struct {
+	Name   string
+	Type   string // counter, gauge or summary
+	Help   string
+	Values map[string]float64 // Labels string (e.g. 'handler="group",code="200"') -> value (the sum for summaries)
+	Counts map[string]float64 // Labels string -> number of observations, for summaries
+}
+
+var metrics = struct {
+	sync.Mutex
+	Items map[string]*metricType
+}{Items: make(map[string]*metricType)}
+
+func init() {
+	registerMetric("vrouter_http_requests_total", "counter", "Total number of HTTP requests by route and status code.")
+	registerMetric("vrouter_http_request_duration_seconds", "summary", "Time spent serving HTTP requests by route.")
+	registerMetric("vrouter_channels_reloads_total", "counter", "Total number of channels data loads by result.")
+	registerMetric("vrouter_ready", "gauge", "Whether all the readiness checks pass.")
+}
+
+func registerMetric(name, kind, help string) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.Items[name] = &metricType{Name: name, Type: kind, Help: help, Values: make(map[string]float64), Counts: make(map[string]float64)}
+}
+
+// Format labels from key-value pairs, e.g. formatLabels("handler", "group") -> 'handler="group"'
+func formatLabels(labels ...string) string {
+	var items []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		items = append(items, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return strings.Join(items, ",")
+}
+
+// Add value to the counter with the specified labels
+func addMetric(name string, value float64, labels ...string) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	if item, ok := metrics.Items[name]; ok {
+		item.Values[formatLabels(labels...)] += value
+	}
+}
+
+// Set value of the gauge with the specified labels
+func setMetric(name string, value float64, labels ...string) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	if item, ok := metrics.Items[name]; ok {
+		item.Values[formatLabels(labels...)] = value
+	}
+}
+
+// Add the observed value to the summary with the specified labels
+func observeMetric(name string, value float64, labels ...string) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	if item, ok := metrics.Items[name]; ok {
+		item.Values[formatLabels(labels...)] += value
+		item.Counts[formatLabels(labels...)]++
+	}
+}
+
+// Write all the metrics in the Prometheus text format
+func writeMetrics(w io.Writer) {
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	var names []string
+	for name := range metrics.Items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		item := metrics.Items[name]
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", item.Name, item.Help, item.Name, item.Type)
+
+		var labels []string
+		for label := range item.Values {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			if item.Type == "summary" {
+				writeMetricValue(w, item.Name+"_sum", label, item.Values[label])
+				writeMetricValue(w, item.Name+"_count", label, item.Counts[label])
+			} else {
+				writeMetricValue(w, item.Name, label, item.Values[label])
+			}
+		}
+	}
+}
+
+func writeMetricValue(w io.Writer, name, label string, value float64) {
+	if label == "" {
+		_, _ = fmt.Fprintf(w, "%s %v\n", name, value)
+	} else {
+		_, _ = fmt.Fprintf(w, "%s{%s} %v\n", name, label, value)
+	}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Product hosted on the instance. Settings are keys of the config file, e.g. 'pathStatic'.
+type ProductType struct {
+	Name     string                 `json:"name"`
+	Host     string                 `json:"host,omitempty"`
+	Settings map[string]interface{} `json:"settings,omitempty"`
+}
+
+// Process serving the product. Every product is served by a separate v-router process
+// listening on a Unix socket, and the main process proxies requests to it.
+// The admin endpoints of the product are served on another Unix socket.
+type productProcessType struct {
+	ProductType
+	Config          GlobalConfigType
+	SocketPath      string
+	AdminSocketPath string
+	// Matches URLs in the versions location of the product
+	LocationRegexp *regexp.Regexp
+	Proxy          *httputil.ReverseProxy
+	AdminProxy     *httputil.ReverseProxy
+	AdminClient    *http.Client
+
+	mutex    sync.Mutex
+	cmd      *exec.Cmd
+	stopping bool
+	done     chan struct{}
+}
+
+var productProcesses []*productProcessType
+
+var productNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Environment variables of the product process environment, which belong to the main process
+var productProcessUnsetEnv = []string{"NOTIFY_SOCKET", "WATCHDOG_USEC", "WATCHDOG_PID", "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"}
+
+// Whether the process serves several products, proxying requests to the product processes
+func isProductsSupervisor() bool {
+	return len(getConfig().Products) > 0 && getConfig().Product == ""
+}
+
+// Parse the 'products' list of the config file. Only fields tagged with `product:"true"` can be set for a product.
+func parseProducts(items interface{}) (products []ProductType, err error) {
+	list, ok := items.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("products should be a list")
+	}
+
+	names := make(map[string]bool)
+	for i, item := range list {
+		values, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("products[%d] should be a mapping", i)
+		}
+		var product ProductType
+		product.Name, _ = values["name"].(string)
+		if !productNameRegexp.MatchString(product.Name) {
+			return nil, fmt.Errorf("products[%d] should have a name of lowercase letters, digits, '-' and '_'", i)
+		}
+		if names[product.Name] {
+			return nil, fmt.Errorf("product %s is defined more than once", product.Name)
+		}
+		names[product.Name] = true
+		if host, ok := values["host"]; ok {
+			if product.Host, ok = host.(string); !ok {
+				return nil, fmt.Errorf("host of product %s should be a string", product.Name)
+			}
+		}
+
+		settings := make(map[string]interface{})
+		delete(values, "name")
+		delete(values, "host")
+		flattenConfigValues("", values, settings)
+		product.Settings = make(map[string]interface{})
+		for key, item := range settings {
+			product.Settings[resolveConfigKeyAlias(key)] = item
+		}
+
+		var config GlobalConfigType
+		value := reflect.ValueOf(&config).Elem()
+		for key, item := range product.Settings {
+			field, ok := getConfigField(value, strings.ReplaceAll(key, ".", ""))
+			if !ok || !isProductConfigField(key) {
+				return nil, fmt.Errorf("key %s can't be set for product %s", key, product.Name)
+			}
+			if err := setConfigField(field, item); err != nil {
+				return nil, fmt.Errorf("wrong value of %s for product %s (%s)", key, product.Name, err.Error())
+			}
+		}
+		products = append(products, product)
+	}
+	return
+}
+
+func isProductConfigField(key string) bool {
+	field, ok := reflect.TypeOf(GlobalConfigType{}).FieldByNameFunc(func(name string) bool {
+		return strings.EqualFold(name, strings.ReplaceAll(key, ".", ""))
+	})
+	return ok && field.Tag.Get("product") == "true"
+}
+
+// Apply settings of the product specified in config.Product. The history file,
+// if not set for the product, gets the product name suffix, e.g. 'history-docs.json'.
+func applyProductConfig(config *GlobalConfigType) error {
+	for _, product := range config.Products {
+		if product.Name != config.Product {
+			continue
+		}
+		historyFile := config.PathHistoryFile
+		value := reflect.ValueOf(config).Elem()
+		for key, item := range product.Settings {
+			field, _ := getConfigField(value, strings.ReplaceAll(key, ".", ""))
+			if err := setConfigField(field, item); err != nil {
+				return err
+			}
+		}
+		if historyFile != "" && config.PathHistoryFile == historyFile {
+			ext := filepath.Ext(historyFile)
+			config.PathHistoryFile = fmt.Sprintf("%s-%s%s", strings.TrimSuffix(historyFile, ext), product.Name, ext)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown product %s", config.Product)
+}
+
+// Get the configuration of the product process
+func getProductConfig(product ProductType) (GlobalConfigType, error) {
+	config := *getConfig()
+	config.Product = product.Name
+	err := applyProductConfig(&config)
+	return config, err
+}
+
+// Check product settings, which would make the product process fail on start
+func validateProducts() error {
+	for _, product := range getConfig().Products {
+		config, err := getProductConfig(product)
+		if err != nil {
+			return err
+		}
+		if config.I18nType != "domain" && config.I18nType != "location" {
+			return fmt.Errorf("product %s: unknown localization method %s", product.Name, config.I18nType)
+		}
+		if fi, err := os.Stat(config.PathStatic + config.PathTpls); err != nil || !fi.IsDir() {
+			return fmt.Errorf("product %s: template directory '%s%s' doesn't exist", product.Name, config.PathStatic, config.PathTpls)
+		}
+		if _, err := parsePublicKeys(config.ChannelsPublicKeys); err != nil {
+			return fmt.Errorf("product %s: %s", product.Name, err.Error())
+		}
+		if err := checkRetention(&config); err != nil {
+			return fmt.Errorf("product %s: %s", product.Name, err.Error())
+		}
+		if err := checkChannelsSource(&config); err != nil {
+			return fmt.Errorf("product %s: channels file '%s' access error (%s)", product.Name, config.PathChannelsFile, err.Error())
+		}
+	}
+	return nil
+}
+
+// Start processes of all the products. Product processes listen on Unix sockets in the temporary directory.
+func startProducts() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	socketDir, err := ioutil.TempDir("", "v-router-products")
+	if err != nil {
+		return err
+	}
+
+	for _, product := range getConfig().Products {
+		config, err := getProductConfig(product)
+		if err != nil {
+			return err
+		}
+		item := &productProcessType{
+			ProductType:     product,
+			Config:          config,
+			SocketPath:      filepath.Join(socketDir, product.Name+".sock"),
+			AdminSocketPath: filepath.Join(socketDir, product.Name+"-admin.sock"),
+			LocationRegexp:  newProductLocationRegexp(config.LocationVersions),
+			done:            make(chan struct{}),
+		}
+		item.Proxy = newProductProxy(item.Name, newUnixSocketTransport(item.SocketPath))
+		adminTransport := newUnixSocketTransport(item.AdminSocketPath)
+		item.AdminProxy = newProductProxy(item.Name, adminTransport)
+		item.AdminClient = &http.Client{Transport: adminTransport, Timeout: 5 * time.Second}
+		productProcesses = append(productProcesses, item)
+		go item.run(executable)
+		log.Infoln(fmt.Sprintf("Product %s: started (host - %q, location - %s, channels file - %s, static files - %s)",
+			item.Name, item.Host, item.Config.LocationVersions, item.Config.PathChannelsFile, item.Config.PathStatic))
+	}
+	return nil
+}
+
+func newProductProxy(name string, transport *http.Transport) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = "product"
+		},
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Errorln(fmt.Sprintf("Product %s: %s", name, err.Error()))
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		},
+	}
+}
+
+func newUnixSocketTransport(socketPath string) *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     getConfig().IdleTimeout,
+	}
+}
+
+// Run the product process, restarting it if it exits, with a backoff
+func (m *productProcessType) run(executable string) {
+	defer close(m.done)
+
+	backoff := time.Second
+	for {
+		cmd := exec.Command(executable, "serve")
+		cmd.Env = m.getEnv()
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		m.mutex.Lock()
+		if m.stopping {
+			m.mutex.Unlock()
+			return
+		}
+		startedAt := time.Now()
+		err := cmd.Start()
+		if err == nil {
+			m.cmd = cmd
+		}
+		m.mutex.Unlock()
+
+		if err == nil {
+			err = cmd.Wait()
+		}
+
+		m.mutex.Lock()
+		m.cmd = nil
+		stopping := m.stopping
+		m.mutex.Unlock()
+		if stopping {
+			return
+		}
+
+		if time.Since(startedAt) > time.Minute {
+			backoff = time.Second
+		}
+		log.Errorln(fmt.Sprintf("Product %s: process exited (%v), restarting in %s", m.Name, err, backoff))
+		time.Sleep(backoff)
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// Environment of the product process: the product process listens only on the Unix sockets.
+// The admin endpoints of the product are protected by the main process, which proxies requests to them.
+func (m *productProcessType) getEnv() (env []string) {
+	for _, item := range os.Environ() {
+		name := strings.SplitN(item, "=", 2)[0]
+		unset := false
+		for _, unsetName := range productProcessUnsetEnv {
+			unset = unset || name == unsetName
+		}
+		if !unset {
+			env = append(env, item)
+		}
+	}
+	return append(env,
+		"VROUTER_PRODUCT="+m.Name,
+		"VROUTER_LISTEN_PORT=",
+		"VROUTER_LISTEN_SOCKET="+m.SocketPath,
+		"VROUTER_LISTEN_SOCKET_MODE=0600",
+		"VROUTER_ADMIN_LISTEN_PORT=",
+		"VROUTER_ADMIN_LISTEN_SOCKET="+m.AdminSocketPath,
+		"VROUTER_ADMIN_USER=",
+		"VROUTER_ADMIN_PASSWORD=",
+		"VROUTER_TLS_CERT_FILE=",
+		"VROUTER_TLS_KEY_FILE=",
+		"VROUTER_HTTP_REDIRECT_PORT=",
+		"VROUTER_DRAIN_DELAY=0s",
+	)
+}
+
+// Send the signal to the product process, if it is running
+func (m *productProcessType) signal(sig os.Signal) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.cmd != nil && m.cmd.Process != nil {
+		_ = m.cmd.Process.Signal(sig)
+	}
+}
+
+// Forward the signal to all the product processes
+func signalProducts(sig os.Signal) {
+	for _, item := range productProcesses {
+		item.signal(sig)
+	}
+}
+
+// Stop all the product processes, waiting for them to exit until the context is done.
+// Processes which didn't exit in time are killed, and reported in the error.
+func stopProducts(ctx context.Context) error {
+	for _, item := range productProcesses {
+		item.mutex.Lock()
+		item.stopping = true
+		item.mutex.Unlock()
+		item.signal(syscall.SIGTERM)
+	}
+	var killed []string
+	for _, item := range productProcesses {
+		select {
+		case <-item.done:
+		case <-ctx.Done():
+			log.Errorln(fmt.Sprintf("Product %s: process didn't exit in time, killing it", item.Name))
+			item.signal(os.Kill)
+			killed = append(killed, item.Name)
+		}
+	}
+	if len(productProcesses) > 0 {
+		_ = os.RemoveAll(filepath.Dir(productProcesses[0].SocketPath))
+	}
+	if len(killed) > 0 {
+		return fmt.Errorf("product processes didn't exit in time: %s", strings.Join(killed, ", "))
+	}
+	return nil
+}
+
+// Get the product of the request by the host (the product host or its subdomain, e.g. 'ru.<host>'),
+// then by the versions location in the URL or in the x-original-uri header.
+// Returns nil if the request matches no product.
+func getProductForRequest(r *http.Request) *productProcessType {
+	if item := getProductByHost(r); item != nil {
+		return item
+	}
+
+	uri := r.Header.Get("x-original-uri")
+	if uri == "" {
+		uri = r.URL.Path
+	}
+	for _, item := range productProcesses {
+		if item.LocationRegexp.MatchString(uri) {
+			return item
+		}
+	}
+	return nil
+}
+
+// Regexp matching URLs in the versions location, with an optional language prefix, e.g. '/en/documentation/...'
+func newProductLocationRegexp(location string) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf("^(/[^/]+)?%s(/|$)", regexp.QuoteMeta(location)))
+}
+
+func getProductByHost(r *http.Request) *productProcessType {
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	for _, item := range productProcesses {
+		if item.Host != "" && (host == item.Host || strings.HasSuffix(host, "."+item.Host)) {
+			return item
+		}
+	}
+	return nil
+}
+
+// Router of the main process in the multi-product mode. Requests are logged by the product processes.
+func newProductsRouter() *mux.Router {
+	r := mux.NewRouter()
+
+	if !isAdminListenerEnabled() {
+		r.PathPrefix("/status").HandlerFunc(productsStatusHandler).Name("status")
+		r.PathPrefix("/health").HandlerFunc(healthCheckHandler).Name("health")
+		r.PathPrefix("/ready").HandlerFunc(readinessHandler).Name("ready")
+	}
+	r.PathPrefix("/").HandlerFunc(productProxyHandler).Name("product")
+
+	return r
+}
+
+// Proxy the request to the product process
+func productProxyHandler(w http.ResponseWriter, r *http.Request) {
+	item := getProductForRequest(r)
+	if item == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	item.Proxy.ServeHTTP(w, r)
+}
+
+// Status of the product, if the request host belongs to the product, otherwise status of all the products
+func productsStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if item := getProductByHost(r); item != nil {
+		item.AdminProxy.ServeHTTP(w, r)
+		return
+	}
+
+	result := make(map[string]json.RawMessage)
+	for _, item := range productProcesses {
+		result[item.Name] = item.getStatus()
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"products": result})
+}
+
+// Proxy the request to the admin listener of the product process, e.g. /products/docs/history -> /history
+func productAdminProxyHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["product"]
+	for _, item := range productProcesses {
+		if item.Name != name {
+			continue
+		}
+		req := r.Clone(r.Context())
+		req.URL.Path = strings.TrimPrefix(r.URL.Path, "/products/"+name)
+		req.URL.RawPath = ""
+		item.AdminProxy.ServeHTTP(w, req)
+		return
+	}
+	http.Error(w, fmt.Sprintf("Unknown product %s", name), http.StatusNotFound)
+}
+
+// Get /status of the product process
+func (m *productProcessType) getStatus() json.RawMessage {
+	errorStatus := func(err error) json.RawMessage {
+		data, _ := json.Marshal(APIStatusResponseType{Status: "error", Msg: err.Error()})
+		return data
+	}
+
+	resp, err := m.AdminClient.Get("http://product/status")
+	if err != nil {
+		return errorStatus(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errorStatus(err)
+	}
+	if !json.Valid(data) {
+		return errorStatus(fmt.Errorf("wrong status response (%s)", resp.Status))
+	}
+	return data
+}
+
+// Check that all the product processes are ready
+func checkProducts() error {
+	var notReady []string
+	for _, item := range productProcesses {
+		resp, err := item.AdminClient.Get("http://product/ready")
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		if err != nil || resp.StatusCode != http.StatusOK {
+			notReady = append(notReady, item.Name)
+		}
+	}
+	if len(notReady) > 0 {
+		sort.Strings(notReady)
+		return fmt.Errorf("products are not ready: %s", strings.Join(notReady, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProductsConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "v-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv("VROUTER_CONFIG_FILE")
+	defer os.Unsetenv("VROUTER_PRODUCT")
+
+	filename := filepath.Join(dir, "config.yaml")
+	content := `
+pathHistoryFile: /var/lib/v-router/history.json
+products:
+  - name: docs
+  - name: platform
+    host: platform.example.com
+    locationVersions: /platform
+    channels: [stable, beta]
+    path:
+      static: /srv/platform
+`
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("VROUTER_CONFIG_FILE", filename)
+	os.Setenv("VROUTER_PRODUCT", "platform")
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Products) != 2 {
+		t.Fatalf("Wrong products %+v", config.Products)
+	}
+	if config.LocationVersions != "/platform" || config.PathStatic != "/srv/platform" || len(config.Channels) != 2 {
+		t.Errorf("Product settings are not applied: %+v", config)
+	}
+	if config.PathHistoryFile != "/var/lib/v-router/history-platform.json" {
+		t.Errorf("Wrong history file of the product %s", config.PathHistoryFile)
+	}
+
+	content = "products:\n  - name: docs\n    listenPort: 8081\n"
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig(); err == nil {
+		t.Errorf("Settings which are not product settings should be rejected")
+	}
+}
+
+func TestGetProductForRequest(t *testing.T) {
+	defer func() { productProcesses = nil }()
+
+	docs := &productProcessType{ProductType: ProductType{Name: "docs"}}
+	docs.LocationRegexp = newProductLocationRegexp("/documentation")
+	platform := &productProcessType{ProductType: ProductType{Name: "platform", Host: "platform.example.com"}}
+	platform.LocationRegexp = newProductLocationRegexp("/platform")
+	productProcesses = []*productProcessType{docs, platform}
+
+	tests := []struct {
+		host, uri, originalURI, expected string
+	}{
+		{"example.com", "/en/documentation/v1/", "", "docs"},
+		{"example.com", "/en/platform/v1/", "", "platform"},
+		{"ru.platform.example.com:8080", "/documentation/v1/", "", "platform"},
+		{"example.com", "/en/includes/version-menu.html", "/ru/platform/v1.2.3/", "platform"},
+		{"example.com", "/unknown/", "", ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.uri, nil)
+		req.Host = test.host
+		if test.originalURI != "" {
+			req.Header.Set("x-original-uri", test.originalURI)
+		}
+		actual := ""
+		if item := getProductForRequest(req); item != nil {
+			actual = item.Name
+		}
+		if actual != test.expected {
+			t.Errorf("%s%s: expected product %q, got %q", test.host, test.uri, test.expected, actual)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/unknown/", nil)
+	rr := httptest.NewRecorder()
+	productProxyHandler(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Request matching no product: expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestProductAdminProxy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "v-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Admin listener of the product process
+	socketPath := filepath.Join(dir, "docs-admin.sock")
+	listener, err := listenUnixSocket(socketPath, "0600")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.RequestURI()))
+	})}
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+
+	docs := &productProcessType{ProductType: ProductType{Name: "docs"}}
+	docs.AdminProxy = newProductProxy(docs.Name, newUnixSocketTransport(socketPath))
+	productProcesses = []*productProcessType{docs}
+	defer func() { productProcesses = nil }()
+	defer changeTestConfig(func(config *GlobalConfigType) { config.Products = []ProductType{docs.ProductType} })()
+
+	r := newAdminRouter()
+	tests := []struct {
+		uri    string
+		status int
+		body   string
+	}{
+		{"/products/docs/history?limit=1", http.StatusOK, "/history?limit=1"},
+		{"/products/docs/debug/resolve?uri=/en/documentation/v1/", http.StatusOK, "/debug/resolve?uri=/en/documentation/v1/"},
+		{"/products/platform/history", http.StatusNotFound, ""},
+		// Endpoints of the channels data are not served for the main process configuration
+		{"/history", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, httptest.NewRequest("GET", test.uri, nil))
+		if recorder.Code != test.status || (test.body != "" && recorder.Body.String() != test.body) {
+			t.Errorf("%s: wrong response, status %d, body %s", test.uri, recorder.Code, recorder.Body.String())
+		}
+	}
+}
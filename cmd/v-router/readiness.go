@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type readinessCheckType struct {
+	Status string `json:"status"`
+	Msg    string `json:"msg,omitempty"`
+}
+
+type readinessResponseType struct {
+	Status string                        `json:"status"`
+	Checks map[string]readinessCheckType `json:"checks"`
+}
+
+// State of the last channels file loading
+type releasesLoadStateType struct {
+	sync.RWMutex
+	LoadedAt  time.Time
+	LastError error
+}
+
+var releasesLoadState releasesLoadStateType
+
+// Non-zero when the server is shutting down
+var shuttingDown int32
+
+// Readiness checks in the order they are performed. The consistency of the channels file with the static files
+// is not a part of the readiness, a missing version directory shouldn't take the whole service down,
+// it's reported in /status.
+var readinessChecks = []struct {
+	Name  string
+	Check func() error
+}{
+	{"shutdown", checkShutdown},
+	{"channels", checkChannelsLoaded},
+	{"static", checkStaticRoot},
+	{"templates", checkTemplates},
+	{"defaultGroup", checkDefaultGroup},
+}
+
+// Readiness checks of the main process in the multi-product mode, the rest is checked by the product processes
+var productsReadinessChecks = []struct {
+	Name  string
+	Check func() error
+}{
+	{"shutdown", checkShutdown},
+	{"products", checkProducts},
+}
+
+func setReleasesLoadState(err error) {
+	releasesLoadState.Lock()
+	defer releasesLoadState.Unlock()
+	releasesLoadState.LastError = err
+	if err == nil {
+		releasesLoadState.LoadedAt = time.Now()
+		addMetric("vrouter_channels_reloads_total", 1, "result", "ok")
+	} else {
+		addMetric("vrouter_channels_reloads_total", 1, "result", "error")
+	}
+}
+
+// Get the error of the last channels data loading, nil if it was loaded
+func getReleasesLoadError() error {
+	releasesLoadState.RLock()
+	defer releasesLoadState.RUnlock()
+	return releasesLoadState.LastError
+}
+
+// Perform all the readiness checks
+func getReadiness() (result readinessResponseType) {
+	result.Status = "ok"
+	result.Checks = make(map[string]readinessCheckType)
+
+	checks := readinessChecks
+	if isProductsSupervisor() {
+		checks = productsReadinessChecks
+	}
+	for _, item := range checks {
+		if err := item.Check(); err != nil {
+			result.Status = "error"
+			result.Checks[item.Name] = readinessCheckType{Status: "error", Msg: err.Error()}
+		} else {
+			result.Checks[item.Name] = readinessCheckType{Status: "ok"}
+		}
+	}
+
+	if result.Status == "ok" {
+		setMetric("vrouter_ready", 1)
+	} else {
+		setMetric("vrouter_ready", 0)
+	}
+	return
+}
+
+// Mark the server as shutting down, so readiness fails during the drain period
+func setShuttingDown() {
+	atomic.StoreInt32(&shuttingDown, 1)
+}
+
+// Check that the server is not shutting down
+func checkShutdown() error {
+	if atomic.LoadInt32(&shuttingDown) != 0 {
+		return fmt.Errorf("shutting down")
+	}
+	return nil
+}
+
+// Check that a valid channels snapshot is loaded
+func checkChannelsLoaded() error {
+	releasesLoadState.RLock()
+	defer releasesLoadState.RUnlock()
+	if releasesLoadState.LoadedAt.IsZero() {
+		if releasesLoadState.LastError != nil {
+			return fmt.Errorf("no valid channels data loaded (%s)", releasesLoadState.LastError.Error())
+		}
+		return fmt.Errorf("no valid channels data loaded")
+	}
+	return nil
+}
+
+// Check that the static files directory is not empty
+func checkStaticRoot() error {
+	files, err := ioutil.ReadDir(getRootFilesPath())
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("directory %s is empty", getRootFilesPath())
+	}
+	return nil
+}
+
+// Check that all the templates parse
+func checkTemplates() error {
+	tplDir := getRootFilesPath() + getConfig().PathTpls
+	return filepath.Walk(tplDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		_, err = template.ParseFiles(path)
+		return err
+	})
+}
+
+// Check that the default group resolves to a version
+func checkDefaultGroup() error {
+	_, err := getVersionFromGroup(getReleasesStatus(), getConfig().DefaultGroup)
+	return err
+}
+
+// Check that versions referenced in the channels file have content
+func checkConsistency() error {
+	result := getConsistencyStatus()
+	if result.CheckedAt.IsZero() {
+		return fmt.Errorf("consistency is not checked yet")
+	}
+	if result.Status != "ok" {
+		return fmt.Errorf("%d version directories are missing", len(result.Missing))
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	validationOK      = "ok"
+	validationSkipped = "skipped"
+)
+
+type resolveOnlyContextKey struct{}
+
+// Response to the request to a documentation location
+type docResponseType struct {
+	Version         string `json:"version"`
+	PageURLRelative string `json:"pageURLRelative"`
+	Validation      string `json:"validation,omitempty"` // 'ok', 'skipped' or the validation error, if the target URL is validated
+	Status          int    `json:"status,omitempty"`
+	Location        string `json:"location,omitempty"`
+	AccelRedirect   string `json:"accelRedirect,omitempty"`
+}
+
+// Result of routing a request
+type resolutionType struct {
+	URI          string            `json:"uri"`
+	Host         string            `json:"host"`
+	Route        string            `json:"route"`
+	PathTemplate string            `json:"pathTemplate"`
+	Vars         map[string]string `json:"vars"`
+	docResponseType
+}
+
+// Routes resolved without calling the handler
+var docResolvers = map[string]func(r *http.Request) docResponseType{
+	"group":             resolveGroupRequest,
+	"groupChannel":      resolveGroupChannelRequest,
+	"groupMinorChannel": resolveGroupChannelRequest,
+	"versionRange":      resolveVersionRangeRequest,
+	"version":           resolveVersionRequest,
+	"rootDoc":           resolveRootDocRequest,
+}
+
+func getLangPrefix(r *http.Request) string {
+	if lang := mux.Vars(r)["lang"]; len(lang) > 0 {
+		return fmt.Sprintf("/%s", lang)
+	}
+	return ""
+}
+
+// Request to /v<group>/. X-Redirect to the stablest version of the group (or to the version of the requested
+// channel, see getChannelOverride), or redirect to the default group if the group is unknown.
+func resolveGroupRequest(r *http.Request) (result docResponseType) {
+	langPrefix := getLangPrefix(r)
+	releases := getRequestReleases(r)
+
+	version, err := getVersionFromGroup(releases, mux.Vars(r)["group"])
+	if err != nil {
+		result.Status = http.StatusFound
+		result.Location = fmt.Sprintf("%s%s/%s/", langPrefix, getConfig().LocationVersions, getConfig().DefaultGroup)
+		return
+	}
+	if channel := getChannelOverride(r, true); channel != "" {
+		if channelVersion, err := getVersionFromChannelAndGroup(releases, channel, mux.Vars(r)["group"]); err == nil {
+			version = channelVersion
+		}
+	}
+
+	result.Version = version
+	result.PageURLRelative = getDocPageURLRelative(r, true)
+	result.Status = http.StatusOK
+	result.AccelRedirect = fmt.Sprintf("%s%s/%s/%s", langPrefix, getConfig().LocationVersions, VersionToURL(version), result.PageURLRelative)
+	return
+}
+
+// Request to /v<group>-<channel>/. Redirect to the version of the channel, if the URL is valid.
+func resolveGroupChannelRequest(r *http.Request) (result docResponseType) {
+	vars := mux.Vars(r)
+
+	result.PageURLRelative = "/"
+	re := regexp.MustCompile(fmt.Sprintf("^/(%s)%s/[^/]+/(.+)$", getLangPattern(), getConfig().LocationVersions))
+	res := re.FindStringSubmatch(r.URL.RequestURI())
+	if res != nil {
+		result.PageURLRelative = res[2]
+	}
+
+	version, err := getVersionFromChannelAndGroup(getRequestReleases(r), vars["channel"], vars["group"])
+	if err == nil {
+		result.Version = version
+		result.Location = fmt.Sprintf("%s%s/%s/%s", getLangPrefix(r), getConfig().LocationVersions, VersionToURL(version), result.PageURLRelative)
+		if !isResolveOnly(r) {
+			err = validateURL(fmt.Sprintf("https://%s%s", r.Host, result.Location))
+		}
+	}
+
+	switch {
+	case err != nil:
+		result.Validation = err.Error()
+		result.Status = http.StatusNotFound
+		result.Location = ""
+	case isResolveOnly(r) && getConfig().UrlValidation:
+		result.Validation = validationSkipped
+		result.Status = http.StatusFound
+	default:
+		result.Validation = validationOK
+		result.Status = http.StatusFound
+	}
+	return
+}
+
+// Request to a version range or a partial version. X-Redirect to the highest matching version
+// of the versions the requested language has.
+func resolveVersionRangeRequest(r *http.Request) (result docResponseType) {
+	lang := mux.Vars(r)["lang"]
+	if lang == "" {
+		lang = getDomainLang(r)
+	}
+	version, err := resolveVersionRange(mux.Vars(r)["version"], getRetainedVersions(lang))
+	if err != nil {
+		result.Status = http.StatusNotFound
+		return
+	}
+
+	result.Version = version
+	result.PageURLRelative = getDocPageURLRelative(r, true)
+	result.Status = http.StatusOK
+	result.AccelRedirect = fmt.Sprintf("%s%s/%s/%s", getLangPrefix(r), getConfig().LocationVersions, VersionToURL(version), result.PageURLRelative)
+	return
+}
+
+// Request to the documentation root. Redirect to the default group.
+// The redirect is temporary if a channel is requested, the default group is served from the channel.
+func resolveRootDocRequest(r *http.Request) (result docResponseType) {
+	var redirectTo string
+
+	langPrefix := getLangPrefix(r)
+	if hasSuffix, _ := regexp.MatchString(fmt.Sprintf("^/[^/]+%s/.+", getConfig().LocationVersions), r.RequestURI); hasSuffix {
+		items := strings.Split(r.RequestURI, fmt.Sprintf("%s/", getConfig().LocationVersions))
+		if len(items) > 1 {
+			redirectTo = strings.Join(items[1:], fmt.Sprintf("%s%s/", langPrefix, getConfig().LocationVersions))
+		}
+	}
+
+	result.PageURLRelative = redirectTo
+	result.Status = http.StatusMovedPermanently
+	if getChannelOverride(r, true) != "" {
+		result.Status = http.StatusFound
+	}
+	result.Location = fmt.Sprintf("%s%s/%s/%s", langPrefix, getConfig().LocationVersions, getConfig().DefaultGroup, redirectTo)
+	return
+}
+
+// Write the response to the request to a documentation location
+func writeDocResponse(w http.ResponseWriter, r *http.Request, result docResponseType) {
+	switch {
+	case result.AccelRedirect != "":
+		w.Header().Set("X-Accel-Redirect", result.AccelRedirect)
+	case result.Location != "":
+		http.Redirect(w, r, result.Location, result.Status)
+	case result.Status == http.StatusNotFound:
+		notFoundHandler(w, r)
+	}
+}
+
+// Route the request the same way the server does, against the published channels data. Documentation
+// locations are resolved without calling handlers, handlers of other routes are not called. Target URLs
+// are not validated, so resolving has no side effects.
+func resolveRequest(router *mux.Router, uri, host string) (result resolutionType, err error) {
+	var match mux.RouteMatch
+
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return result, fmt.Errorf("can't parse uri %s (%s)", uri, err.Error())
+	}
+	req.RequestURI = uri
+	req.Host = host
+	req.Header.Set("x-original-uri", uri)
+	req = req.WithContext(context.WithValue(req.Context(), resolveOnlyContextKey{}, true))
+	result.URI = uri
+	result.Host = host
+
+	if !router.Match(req, &match) || match.Route == nil {
+		return result, fmt.Errorf("no route matches %s", uri)
+	}
+	result.Route = match.Route.GetName()
+	result.PathTemplate, _ = match.Route.GetPathTemplate()
+	result.Vars = match.Vars
+	req = mux.SetURLVars(req, match.Vars)
+
+	if resolver, ok := docResolvers[result.Route]; ok {
+		result.docResponseType = resolver(req)
+	}
+	return
+}
+
+// Whether the request is only resolved, see resolveRequest
+func isResolveOnly(r *http.Request) bool {
+	return r.Context().Value(resolveOnlyContextKey{}) != nil
+}
+
+// Explain how the request is routed, e.g. /debug/resolve?uri=/en/documentation/v1.2-beta/foo&host=docs.example.com.
+// The request is resolved for the host parameter, or for the host of the debug request.
+func debugResolveHandler(w http.ResponseWriter, r *http.Request) {
+	uri := r.URL.Query().Get("uri")
+	if !strings.HasPrefix(uri, "/") {
+		http.Error(w, "The uri parameter should be a path, e.g. /en/documentation/v1/", http.StatusBadRequest)
+		return
+	}
+	if _, err := url.ParseRequestURI(uri); err != nil {
+		http.Error(w, fmt.Sprintf("Can't parse the uri parameter (%s)", err.Error()), http.StatusBadRequest)
+		return
+	}
+	host := r.URL.Query().Get("host")
+	if host == "" {
+		host = r.Host
+	}
+
+	result, err := resolveRequest(newRouter(), uri, host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(result)
+}
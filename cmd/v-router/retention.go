@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Versions retired by the retention policy, shown in /status
+type retentionStatusType struct {
+	KeepPatches int      `json:"keepPatches,omitempty"`
+	KeepSince   string   `json:"keepSince,omitempty"`
+	Retired     []string `json:"retired"`
+}
+
+func isRetentionEnabled() bool {
+	return getConfig().RetentionKeepPatches > 0 || getConfig().RetentionKeepSince != ""
+}
+
+// Check the retention policy settings
+func checkRetention(config *GlobalConfigType) error {
+	if config.RetentionKeepPatches < 0 {
+		return fmt.Errorf("retention keep patches should not be negative, got %d", config.RetentionKeepPatches)
+	}
+	if config.RetentionKeepSince != "" {
+		if _, err := time.Parse(versionDateLayout, config.RetentionKeepSince); err != nil {
+			return fmt.Errorf("can't parse retention keep since %s, it should be YYYY-MM-DD", config.RetentionKeepSince)
+		}
+	}
+	return nil
+}
+
+// Get the release date of the version: the releaseDate of the version metadata, or the modification time
+// of the version directory in the static files directory
+func getVersionReleaseDate(releases *ReleasesStatusType, version string) (time.Time, bool) {
+	if releaseDate := getVersionMetadata(releases, version).ReleaseDate; releaseDate != "" {
+		if result, err := time.Parse(versionDateLayout, releaseDate); err == nil {
+			return result, true
+		}
+	}
+	for _, lang := range getConfig().Languages {
+		if fi, err := os.Stat(fmt.Sprintf("%s/%s", getVersionsPath(lang), VersionToURL(version))); err == nil {
+			return fi.ModTime(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Get the name of the group of the version: the first group of the channels file the version belongs to,
+// or v<major> if there is no such group
+func getVersionGroup(releases *ReleasesStatusType, version versionType) string {
+	for _, group := range releases.Groups {
+		if _, err := fmt.Sscanf(strings.TrimPrefix(group.Name, "v"), "%d", new(int)); err == nil && isVersionInGroup(version, group.Name) {
+			return group.Name
+		}
+	}
+	return fmt.Sprintf("v%d", version.Major)
+}
+
+// Versions retired by the retention policy. They are computed when the channels data is published,
+// the configuration is reloaded, or by the consistency checker, not on every request.
+var retiredVersions atomic.Value
+
+func updateRetiredVersions(releases *ReleasesStatusType) {
+	retiredVersions.Store(computeRetiredVersions(releases))
+}
+
+func getRetiredVersions() []string {
+	if versions, ok := retiredVersions.Load().([]string); ok {
+		return versions
+	}
+	return nil
+}
+
+// Compute versions retired by the retention policy. The last RetentionKeepPatches versions of every group,
+// versions released since RetentionKeepSince, and versions of channels (including scheduled and canary ones) are kept.
+func computeRetiredVersions(releases *ReleasesStatusType) (retired []string) {
+	if !isRetentionEnabled() {
+		return nil
+	}
+
+	kept := make(map[string]bool)
+	for _, group := range releases.Groups {
+		for _, channel := range group.Channels {
+			kept[strings.TrimPrefix(channel.Version, "v")] = true
+			for _, canary := range channel.Canary {
+				kept[strings.TrimPrefix(canary.Version, "v")] = true
+			}
+		}
+	}
+	for _, change := range releases.Upcoming {
+		kept[strings.TrimPrefix(change.Version, "v")] = true
+	}
+	keepSince, err := time.Parse(versionDateLayout, getConfig().RetentionKeepSince)
+	hasKeepSince := err == nil
+
+	type groupVersionType struct {
+		Version string
+		Item    versionType
+	}
+	groups := make(map[string][]groupVersionType)
+	for _, version := range getKnownVersions(releases) {
+		item, err := parseVersion(version)
+		if err != nil {
+			continue
+		}
+		name := getVersionGroup(releases, item)
+		groups[name] = append(groups[name], groupVersionType{Version: version, Item: item})
+	}
+
+	for _, versions := range groups {
+		sort.Slice(versions, func(i, j int) bool {
+			return compareVersions(versions[i].Item, versions[j].Item) > 0
+		})
+		for i, version := range versions {
+			if kept[strings.TrimPrefix(version.Version, "v")] || i < getConfig().RetentionKeepPatches {
+				continue
+			}
+			if hasKeepSince {
+				if releaseDate, ok := getVersionReleaseDate(releases, version.Version); !ok || !releaseDate.Before(keepSince) {
+					continue
+				}
+			}
+			retired = append(retired, version.Version)
+		}
+	}
+	sort.Strings(retired)
+	return
+}
+
+func isVersionRetired(version string) bool {
+	for _, item := range getRetiredVersions() {
+		if strings.TrimPrefix(item, "v") == strings.TrimPrefix(version, "v") {
+			return true
+		}
+	}
+	return false
+}
+
+func getRetentionStatus() *retentionStatusType {
+	if !isRetentionEnabled() {
+		return nil
+	}
+	result := &retentionStatusType{
+		KeepPatches: getConfig().RetentionKeepPatches,
+		KeepSince:   getConfig().RetentionKeepSince,
+		Retired:     getRetiredVersions(),
+	}
+	if result.Retired == nil {
+		result.Retired = []string{}
+	}
+	return result
+}
+
+// Request to an exact version. Redirect to the same page in the current version of the group,
+// or to the root of the current version if it has no such page, if the version is retired.
+func resolveVersionRequest(r *http.Request) (result docResponseType) {
+	langPrefix, lang, version, pageURLRelative, _ := parseVersionPagePath(r.URL.Path)
+	result.Version = URLToVersion(mux.Vars(r)["version"])
+	result.PageURLRelative = pageURLRelative
+	result.Status = http.StatusOK
+
+	if !isVersionRetired(version) {
+		return
+	}
+	versionItem, err := parseVersion(version)
+	if err != nil {
+		return
+	}
+	// The redirect is permanent, so it doesn't depend on canary versions of the client
+	releases := getReleasesStatus()
+	currentVersion, err := getVersionFromGroup(releases, getVersionGroup(releases, versionItem))
+	if err != nil {
+		currentVersion, err = getVersionFromChannelAndGroup(releases, getConfig().DefaultChannel, getConfig().DefaultGroup)
+		if err != nil {
+			return
+		}
+	}
+	if lang == "" {
+		lang = getDomainLang(r)
+	}
+
+	result.Version = currentVersion
+	result.Status = http.StatusMovedPermanently
+	result.Location = getVersionPageURL(langPrefix, lang, currentVersion, pageURLRelative)
+	return
+}
+
+// Serve pages of exact versions, requests for versions retired by the retention policy are redirected
+func versionHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if result := resolveVersionRequest(r); result.Location != "" {
+			writeDocResponse(w, r, result)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Get versions of the language, except versions retired by the retention policy
+func getRetainedVersions(lang string) (versions []string) {
+	retired := make(map[string]bool)
+	for _, version := range getRetiredVersions() {
+		retired[version] = true
+	}
+	for _, version := range getLangKnownVersions(getReleasesStatus(), lang) {
+		if !retired[version] {
+			versions = append(versions, version)
+		}
+	}
+	return
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+type ScheduledVersionType struct {
+	Version             string    `json:"version"`
+	ActivateAt          time.Time `json:"activateAt" yaml:"activateAt"`
+	VersionMetadataType `yaml:",inline"`
+	Canary              []CanaryVersionType `json:"canary,omitempty" yaml:"canary,omitempty"`
+}
+
+type upcomingChangeType struct {
+	Group      string    `json:"group"`
+	Channel    string    `json:"channel"`
+	Version    string    `json:"version"`
+	ActivateAt time.Time `json:"activateAt"`
+}
+
+// Resolve scheduled assignments of channels for the specified time.
+// For every channel the entry and the schedule item activated the latest wins,
+// entries without 'activateAt' are active from the beginning.
+// Assignments activated after the specified time are collected to releases.Upcoming.
+func applyChannelsSchedule(releases *ReleasesStatusType, now time.Time) {
+	releases.Upcoming = nil
+
+	for idx, group := range releases.Groups {
+		var channels []ChannelType
+		active := make(map[string]int)            // Channel name -> index in channels
+		activatedAt := make(map[string]time.Time) // Channel name -> activation time of the active assignment
+
+		for _, channel := range group.Channels {
+			assignments := []ScheduledVersionType{{Version: channel.Version, VersionMetadataType: channel.VersionMetadataType, Canary: channel.Canary}}
+			if channel.ActivateAt != nil {
+				assignments[0].ActivateAt = *channel.ActivateAt
+			}
+			for _, item := range channel.Schedule {
+				// Schedule items take effect only after the entry itself is activated
+				if item.ActivateAt.After(assignments[0].ActivateAt) {
+					assignments = append(assignments, item)
+				}
+			}
+
+			for _, item := range assignments {
+				if item.ActivateAt.After(now) {
+					releases.Upcoming = append(releases.Upcoming, upcomingChangeType{
+						Group:      group.Name,
+						Channel:    channel.Name,
+						Version:    item.Version,
+						ActivateAt: item.ActivateAt,
+					})
+					continue
+				}
+				i, ok := active[channel.Name]
+				if !ok {
+					active[channel.Name] = len(channels)
+					activatedAt[channel.Name] = item.ActivateAt
+					channels = append(channels, ChannelType{Name: channel.Name, Version: item.Version, VersionMetadataType: item.VersionMetadataType, Canary: item.Canary})
+				} else if !item.ActivateAt.Before(activatedAt[channel.Name]) {
+					activatedAt[channel.Name] = item.ActivateAt
+					channels[i].Version = item.Version
+					channels[i].VersionMetadataType = item.VersionMetadataType
+					channels[i].Canary = item.Canary
+				}
+			}
+		}
+		releases.Groups[idx].Channels = channels
+	}
+
+	sort.SliceStable(releases.Upcoming, func(i, j int) bool {
+		return releases.Upcoming[i].ActivateAt.Before(releases.Upcoming[j].ActivateAt)
+	})
+}
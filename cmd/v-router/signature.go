@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Suffix of the detached signature of the channels file, e.g. channels.yaml.sig
+const signatureSuffix = ".sig"
+
+// Error of the signature verification, the channels data is rejected
+type signatureError struct {
+	Msg string
+}
+
+func (e *signatureError) Error() string {
+	return e.Msg
+}
+
+type channelsSignatureStatusType struct {
+	Status     string     `json:"status"`
+	Msg        string     `json:"msg,omitempty"`
+	Key        string     `json:"key,omitempty"` // The public key of the last valid signature
+	VerifiedAt *time.Time `json:"verifiedAt,omitempty"`
+}
+
+// Result of the signature verification
+type signatureCheckType struct {
+	Key string // The public key of the valid signature
+	Err error
+}
+
+// Results of signature verifications by the checksum of the keys, the data and the signature, to verify only changes,
+// and the result of the last verification of the channels data
+var channelsSignatures = struct {
+	sync.Mutex
+	Checked    map[[sha256.Size]byte]signatureCheckType
+	LastError  error
+	Key        string
+	VerifiedAt time.Time
+}{Checked: make(map[[sha256.Size]byte]signatureCheckType)}
+
+func init() {
+	registerMetric("vrouter_channels_signature_verifications_total", "counter", "Total number of signature verifications of the channels data by result.")
+	registerMetric("vrouter_channels_signature_valid", "gauge", "Whether the last channels data has a valid signature.")
+}
+
+// Signatures are verified if public keys are configured
+func isChannelsSignatureRequired() bool {
+	return len(getConfig().ChannelsPublicKeys) > 0
+}
+
+// Identify the configured public keys, to verify the data again when they change
+func getChannelsPublicKeysID() string {
+	return strings.Join(getConfig().ChannelsPublicKeys, ",")
+}
+
+// Parse ed25519 public keys in base64: raw 32 bytes keys, or DER encoded keys (the content of a PEM file)
+func parsePublicKeys(items []string) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	for _, item := range items {
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(item))
+		if err != nil {
+			return nil, fmt.Errorf("can't decode public key %s (%s)", item, err.Error())
+		}
+		if len(data) == ed25519.PublicKeySize {
+			keys = append(keys, data)
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse public key %s (%s)", item, err.Error())
+		}
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public key %s is not an ed25519 key", item)
+		}
+		keys = append(keys, edKey)
+	}
+	return keys, nil
+}
+
+// Decode the signature: raw 64 bytes, or base64
+func decodeSignature(data []byte) ([]byte, error) {
+	if len(data) == ed25519.SignatureSize {
+		return data, nil
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+}
+
+// Get the location of the detached signature of the channels data, e.g. https://example.com/channels.yaml.sig?v=1
+func getSignatureLocation(location string) string {
+	if isHTTPChannelsSource(location) {
+		if sourceURL, err := url.Parse(location); err == nil {
+			sourceURL.Path += signatureSuffix
+			return sourceURL.String()
+		}
+	}
+	return location + signatureSuffix
+}
+
+// Verify the detached signature of the data with the configured public keys. A nil signature is a missing one.
+func verifyChannelsSignature(location string, data, signature []byte) error {
+	hash := sha256.New()
+	hash.Write([]byte(getChannelsPublicKeysID()))
+	hash.Write([]byte{0})
+	hash.Write(data)
+	hash.Write([]byte{0})
+	hash.Write(signature)
+	var checksum [sha256.Size]byte
+	copy(checksum[:], hash.Sum(nil))
+	if signature == nil {
+		// Missing and empty signatures differ
+		checksum[0] ^= 0xff
+	}
+
+	channelsSignatures.Lock()
+	defer channelsSignatures.Unlock()
+	if check, ok := channelsSignatures.Checked[checksum]; ok {
+		if check.Err == nil {
+			channelsSignatures.Key = check.Key
+		}
+		return check.Err
+	}
+
+	var check signatureCheckType
+	var result string
+	switch {
+	case signature == nil:
+		result = "missing"
+		check.Err = &signatureError{Msg: fmt.Sprintf("signature of %s is missing", location)}
+	default:
+		check.Key, check.Err = doVerifyChannelsSignature(location, data, signature)
+		result = "valid"
+		if check.Err != nil {
+			result = "invalid"
+		}
+	}
+	addMetric("vrouter_channels_signature_verifications_total", 1, "result", result)
+
+	// The cache grows with every change of the data
+	if len(channelsSignatures.Checked) > 1000 {
+		channelsSignatures.Checked = make(map[[sha256.Size]byte]signatureCheckType)
+	}
+	channelsSignatures.Checked[checksum] = check
+	if check.Err == nil {
+		channelsSignatures.Key = check.Key
+	}
+	return check.Err
+}
+
+func doVerifyChannelsSignature(location string, data, signature []byte) (string, error) {
+	keys, err := parsePublicKeys(getConfig().ChannelsPublicKeys)
+	if err != nil {
+		return "", err
+	}
+	signature, err = decodeSignature(signature)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return "", &signatureError{Msg: fmt.Sprintf("signature of %s can't be decoded", location)}
+	}
+	for i, key := range keys {
+		if ed25519.Verify(key, data, signature) {
+			return getConfig().ChannelsPublicKeys[i], nil
+		}
+	}
+	return "", &signatureError{Msg: fmt.Sprintf("signature of %s is not valid", location)}
+}
+
+// Verify the data of the file with its detached signature file
+func verifyFileSignature(filename string, data []byte) error {
+	signature, err := ioutil.ReadFile(getSignatureLocation(filename))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return verifyChannelsSignature(filename, data, signature)
+}
+
+// Save the result of the signature verification of the channels data. Other errors (e.g. the file can't be read)
+// don't change the result.
+func setChannelsSignatureStatus(err error) {
+	if _, ok := err.(*signatureError); err != nil && !ok {
+		return
+	}
+
+	channelsSignatures.Lock()
+	defer channelsSignatures.Unlock()
+	if err != nil {
+		if channelsSignatures.LastError == nil || channelsSignatures.LastError.Error() != err.Error() {
+			log.Errorln(fmt.Sprintf("Channels data is rejected: %s", err.Error()))
+		}
+		setMetric("vrouter_channels_signature_valid", 0)
+	} else {
+		channelsSignatures.VerifiedAt = time.Now()
+		setMetric("vrouter_channels_signature_valid", 1)
+	}
+	channelsSignatures.LastError = err
+}
+
+// Verify signatures again, e.g. after the public keys are changed
+func resetSignatureCache() {
+	channelsSignatures.Lock()
+	defer channelsSignatures.Unlock()
+	channelsSignatures.Checked = make(map[[sha256.Size]byte]signatureCheckType)
+}
+
+func getChannelsSignatureStatus() *channelsSignatureStatusType {
+	if !isChannelsSignatureRequired() {
+		return nil
+	}
+
+	channelsSignatures.Lock()
+	defer channelsSignatures.Unlock()
+	result := &channelsSignatureStatusType{Status: "ok", Key: channelsSignatures.Key}
+	if channelsSignatures.LastError != nil {
+		result.Status = "error"
+		result.Msg = channelsSignatures.LastError.Error()
+	}
+	if !channelsSignatures.VerifiedAt.IsZero() {
+		verifiedAt := channelsSignatures.VerifiedAt.UTC()
+		result.VerifiedAt = &verifiedAt
+	}
+	return result
+}
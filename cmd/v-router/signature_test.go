@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSignedChannelsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "v-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile("testdata/channels.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "channels.yaml")
+	write := func(content []byte, signature []byte) {
+		if err := ioutil.WriteFile(filename, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename+".sig", signature, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restoreConfig := changeTestConfig(func(config *GlobalConfigType) { config.PathChannelsFile = filename })
+	defer func() {
+		restoreConfig()
+		setChannelsSignatureStatus(nil)
+	}()
+
+	// Both raw and DER encoded keys are accepted, any of the keys can sign
+	for _, key := range []string{base64.StdEncoding.EncodeToString(publicKey), base64.StdEncoding.EncodeToString(der)} {
+		defer changeTestConfig(func(config *GlobalConfigType) {
+			config.ChannelsPublicKeys = []string{base64.StdEncoding.EncodeToString(otherKey), key}
+		})()
+		resetSignatureCache()
+
+		write(data, []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, data))+"\n"))
+		if _, err := readChannelsData(); err != nil {
+			t.Fatal(err)
+		}
+		if status := getChannelsSignatureStatus(); status.Status != "ok" || status.Key != key {
+			t.Errorf("Wrong signature status %+v", status)
+		}
+	}
+
+	// Raw signatures
+	write(data, ed25519.Sign(privateKey, data))
+	if _, err := readChannelsData(); err != nil {
+		t.Errorf("Raw signature should be accepted (%s)", err.Error())
+	}
+
+	// Changed data without a new signature
+	write(append(data, '\n'), ed25519.Sign(privateKey, data))
+	if _, err := readChannelsData(); err == nil {
+		t.Errorf("Badly signed data should be rejected")
+	}
+	if status := getChannelsSignatureStatus(); status.Status != "error" {
+		t.Errorf("Wrong signature status %+v", status)
+	}
+
+	os.Remove(filename + ".sig")
+	if _, err := readChannelsData(); err == nil || err.Error() != "signature of "+filename+" is missing" {
+		t.Errorf("Unsigned data should be rejected, got %v", err)
+	}
+
+	if _, err := parsePublicKeys([]string{"bm90IGEga2V5"}); err == nil {
+		t.Errorf("Wrong public key should be reported")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The first file descriptor passed by systemd (SD_LISTEN_FDS_START)
+const systemdListenFdsStart = 3
+
+// Get listeners passed by systemd socket activation (LISTEN_PID, LISTEN_FDS).
+// Returns nil if the process is not socket activated.
+func systemdListeners() (listeners []net.Listener, err error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// Don't pass the descriptors to child processes
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+
+	for i := 0; i < fds; i++ {
+		name := fmt.Sprintf("LISTEN_FD_%d", systemdListenFdsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(systemdListenFdsStart+i), name)
+		listener, err := net.FileListener(file)
+		_ = file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("can't use socket %s passed by systemd (%s)", name, err.Error())
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// Send a notification to systemd (sd_notify), e.g. 'READY=1'.
+// Does nothing if the service is not started with Type=notify.
+func sdNotify(state string) error {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return nil
+	}
+	// Abstract socket namespace
+	if strings.HasPrefix(socketPath, "@") {
+		socketPath = "\x00" + socketPath[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Get the watchdog interval set by systemd (WatchdogSec), or 0 if the watchdog is disabled
+func systemdWatchdogInterval() time.Duration {
+	if pid, err := strconv.Atoi(os.Getenv("WATCHDOG_PID")); err == nil && pid != os.Getpid() {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Send watchdog keep-alive notifications to systemd twice per watchdog interval
+func runSystemdWatchdog() {
+	interval := systemdWatchdogInterval()
+	if interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			log.Errorln(fmt.Sprintf("Can't notify systemd watchdog (%s)", err.Error()))
+		}
+	}
+}
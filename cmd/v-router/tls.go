@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Keeps TLS certificate and reloads it when certificate or key files change on disk
+// (e.g. cert-manager writes a new certificate to a mounted volume)
+type certReloaderType struct {
+	sync.RWMutex
+	certFile    string
+	keyFile     string
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloaderType, error) {
+	result := &certReloaderType{certFile: certFile, keyFile: keyFile}
+	if err := result.reload(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Load the certificate if the files were modified since the last loading
+func (m *certReloaderType) reload() error {
+	certInfo, err := os.Stat(m.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(m.keyFile)
+	if err != nil {
+		return err
+	}
+
+	m.RLock()
+	unchanged := m.cert != nil && certInfo.ModTime().Equal(m.certModTime) && keyInfo.ModTime().Equal(m.keyModTime)
+	m.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(m.certFile, m.keyFile)
+	if err != nil {
+		return fmt.Errorf("can't load TLS certificate %s (%s)", m.certFile, err.Error())
+	}
+
+	m.Lock()
+	m.cert = &cert
+	m.certModTime = certInfo.ModTime()
+	m.keyModTime = keyInfo.ModTime()
+	m.Unlock()
+	log.Infoln(fmt.Sprintf("TLS certificate %s loaded", m.certFile))
+	return nil
+}
+
+// Periodically reload the certificate. The previous certificate is kept on error.
+func (m *certReloaderType) watch(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := m.reload(); err != nil {
+			log.Errorln(err.Error())
+		}
+	}
+}
+
+func (m *certReloaderType) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.cert, nil
+}
+
+func isTLSEnabled() bool {
+	return getConfig().TlsCertFile != "" && getConfig().TlsKeyFile != ""
+}
+
+// TLS configuration with modern defaults
+func newTLSConfig(reloader *certReloaderType) *tls.Config {
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+		GetCertificate: reloader.GetCertificate,
+	}
+}
+
+// Redirect HTTP requests to HTTPS
+func httpsRedirectHandler(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = h
+	}
+	if getConfig().ListenPort != "443" {
+		host = net.JoinHostPort(host, getConfig().ListenPort)
+	}
+	http.Redirect(w, r, fmt.Sprintf("https://%s%s", host, r.URL.RequestURI()), http.StatusMovedPermanently)
+}
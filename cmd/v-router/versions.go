@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type versionType struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+	Build      string
+}
+
+// Version constraint from URL, e.g. 'v1.2.x', '~1.2' or '1.2.3'
+type versionRangeType struct {
+	Major    int
+	Minor    int
+	Patch    int
+	HasMinor bool
+	HasPatch bool
+	IsTilde  bool
+}
+
+// URL pattern for the version range route (gorilla/mux accepts only non-capturing groups).
+// Versions starting with 'v' without a range suffix (e.g. 'v1.2') are not matched to keep existing routes.
+const versionRangeURLPattern = `~v?[0-9]+(?:\.[0-9]+)*|v?[0-9]+(?:\.[0-9]+)*\.x|[0-9]+(?:\.[0-9]+)*`
+
+var versionRe = regexp.MustCompile(`^v?([0-9]+)\.([0-9]+)\.([0-9]+)(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+var versionRangeRe = regexp.MustCompile(`^(~)?v?([0-9]+)(\.([0-9]+|x))?(\.([0-9]+|x))?$`)
+var naturalPartsRe = regexp.MustCompile(`[0-9]+|[^0-9]+`)
+
+// Parse version like v1.2.3-alpha.1+fix6
+func parseVersion(version string) (result versionType, err error) {
+	res := versionRe.FindStringSubmatch(version)
+	if res == nil {
+		return result, fmt.Errorf("can't parse version %s", version)
+	}
+	result.Major, _ = strconv.Atoi(res[1])
+	result.Minor, _ = strconv.Atoi(res[2])
+	result.Patch, _ = strconv.Atoi(res[3])
+	result.Prerelease = strings.TrimPrefix(res[4], "-")
+	result.Build = strings.TrimPrefix(res[5], "+")
+	return
+}
+
+// Compare versions. Returns -1 if a < b, 0 if a == b and 1 if a > b.
+// Unlike semver, build metadata (e.g. '+fix6') takes part in the comparison.
+func compareVersions(a, b versionType) int {
+	if a.Major != b.Major {
+		return compareInts(a.Major, b.Major)
+	}
+	if a.Minor != b.Minor {
+		return compareInts(a.Minor, b.Minor)
+	}
+	if a.Patch != b.Patch {
+		return compareInts(a.Patch, b.Patch)
+	}
+	if a.Prerelease != b.Prerelease {
+		// A version without prerelease has the higher precedence
+		if a.Prerelease == "" {
+			return 1
+		}
+		if b.Prerelease == "" {
+			return -1
+		}
+		return compareNatural(a.Prerelease, b.Prerelease)
+	}
+	return compareNatural(a.Build, b.Build)
+}
+
+func compareInts(a, b int) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// Compare strings, treating digit sequences as numbers (fix9 < fix10).
+func compareNatural(a, b string) int {
+	aParts := naturalPartsRe.FindAllString(a, -1)
+	bParts := naturalPartsRe.FindAllString(b, -1)
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aNum, aErr := strconv.Atoi(aParts[i])
+		bNum, bErr := strconv.Atoi(bParts[i])
+		if aErr == nil && bErr == nil {
+			if aNum != bNum {
+				return compareInts(aNum, bNum)
+			}
+			continue
+		}
+		if aParts[i] != bParts[i] {
+			return strings.Compare(aParts[i], bParts[i])
+		}
+	}
+	return compareInts(len(aParts), len(bParts))
+}
+
+// Parse version range from URL.
+// Supported forms: 'v1.x', '1.2.x', '~1.2', '~1.2.3', '1', '1.2', '1.2.3'.
+func parseVersionRange(versionRange string) (result versionRangeType, err error) {
+	res := versionRangeRe.FindStringSubmatch(versionRange)
+	if res == nil {
+		return result, fmt.Errorf("can't parse version range %s", versionRange)
+	}
+	result.IsTilde = res[1] == "~"
+	result.Major, _ = strconv.Atoi(res[2])
+	if res[4] != "" && res[4] != "x" {
+		result.Minor, _ = strconv.Atoi(res[4])
+		result.HasMinor = true
+	}
+	if res[6] != "" && res[6] != "x" {
+		if !result.HasMinor {
+			return result, fmt.Errorf("can't parse version range %s", versionRange)
+		}
+		result.Patch, _ = strconv.Atoi(res[6])
+		result.HasPatch = true
+	}
+	return
+}
+
+// Check whether version satisfies the range. Prerelease versions never match.
+func (m *versionRangeType) match(version versionType) bool {
+	if version.Prerelease != "" {
+		return false
+	}
+	if version.Major != m.Major {
+		return false
+	}
+	if !m.HasMinor {
+		return true
+	}
+	if version.Minor != m.Minor {
+		return false
+	}
+	if !m.HasPatch {
+		return true
+	}
+	if m.IsTilde {
+		return version.Patch >= m.Patch
+	}
+	return version.Patch == m.Patch
+}
+
+// Get the highest version satisfying the range
+func resolveVersionRange(versionRange string, versions []string) (result string, err error) {
+	var bestVersion versionType
+
+	rangeItem, err := parseVersionRange(versionRange)
+	if err != nil {
+		return "", err
+	}
+
+	for _, version := range versions {
+		versionItem, err := parseVersion(version)
+		if err != nil {
+			continue
+		}
+		if !rangeItem.match(versionItem) {
+			continue
+		}
+		if result == "" || compareVersions(versionItem, bestVersion) > 0 {
+			result = version
+			bestVersion = versionItem
+		}
+	}
+
+	if result == "" {
+		return "", fmt.Errorf("no matching version for %s", versionRange)
+	}
+	return result, nil
+}
+
+// Get path of the directory with versions for specified language
+// E.g. root/en/documentation
+func getVersionsPath(lang string) string {
+	return fmt.Sprintf("%s/%s%s", getRootFilesPath(), lang, getConfig().LocationVersions)
+}
+
+// Regexp of paths of version pages, compiled again only when the languages or the versions location change
+var versionPagePathRegexp = struct {
+	sync.Mutex
+	Pattern string
+	Regexp  *regexp.Regexp
+}{}
+
+func getVersionPagePathRegexp() *regexp.Regexp {
+	pattern := fmt.Sprintf("^(/(%s))?%s/([^/]+)/(.*)$", getLangPattern(), regexp.QuoteMeta(getConfig().LocationVersions))
+
+	versionPagePathRegexp.Lock()
+	defer versionPagePathRegexp.Unlock()
+	if versionPagePathRegexp.Regexp == nil || versionPagePathRegexp.Pattern != pattern {
+		versionPagePathRegexp.Pattern = pattern
+		versionPagePathRegexp.Regexp = regexp.MustCompile(pattern)
+	}
+	return versionPagePathRegexp.Regexp
+}
+
+// Parse the path of a page of a version, e.g. /en/documentation/v1.2.3-plus-fix6/install.html.
+// The language is empty with the domain localization method.
+func parseVersionPagePath(pagePath string) (langPrefix, lang, version, pageURLRelative string, ok bool) {
+	res := getVersionPagePathRegexp().FindStringSubmatch(pagePath)
+	if res == nil {
+		return "", "", "", "", false
+	}
+	return res[1], res[2], URLToVersion(res[3]), res[4], true
+}
+
+// Get the language from the host with the domain localization method, e.g. 'ru' for ru.example.com
+func getDomainLang(r *http.Request) string {
+	host := strings.Split(r.Host, ".")[0]
+	for _, lang := range getConfig().Languages {
+		if host == lang {
+			return lang
+		}
+	}
+	return getConfig().Languages[0]
+}
+
+// Get the URL of the page in the version, or the URL of the version root if the version has no such page
+func getVersionPageURL(langPrefix, lang, version, pageURLRelative string) string {
+	versionURL := fmt.Sprintf("%s%s/%s/", langPrefix, getConfig().LocationVersions, VersionToURL(version))
+	filename := path.Join(getVersionsPath(lang), VersionToURL(version), pageURLRelative)
+	if pageURLRelative == "" || strings.HasSuffix(pageURLRelative, "/") {
+		filename = path.Join(filename, "index.html")
+	}
+	if _, err := os.Stat(filename); err != nil {
+		return versionURL
+	}
+	return versionURL + pageURLRelative
+}
+
+// Get versions which have a directory in the static files directory of the language
+func getLangVersions(lang string) (versions []string) {
+	files, err := ioutil.ReadDir(getVersionsPath(lang))
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		version := URLToVersion(file.Name())
+		if _, err := parseVersion(version); err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return
+}
+
+// Get versions which have a directory in the static files directory (for all languages)
+func getStaticVersions() (versions []string) {
+	found := make(map[string]bool)
+	for _, lang := range getConfig().Languages {
+		for _, version := range getLangVersions(lang) {
+			if !found[version] {
+				found[version] = true
+				versions = append(versions, version)
+			}
+		}
+	}
+	sort.Strings(versions)
+	return
+}
+
+// Get versions of the language: versions with a directory of the language, and versions of the channels file,
+// unless they have directories of other languages only (e.g. if the static files directory has no versions at all)
+func getLangKnownVersions(releases *ReleasesStatusType, lang string) (versions []string) {
+	found := make(map[string]bool)
+	for _, version := range getLangVersions(lang) {
+		found[version] = true
+		versions = append(versions, version)
+	}
+	otherLangs := make(map[string]bool)
+	for _, version := range getStaticVersions() {
+		otherLangs[version] = !found[version]
+	}
+	for _, group := range releases.Groups {
+		for _, channel := range group.Channels {
+			if !found[channel.Version] && !otherLangs[channel.Version] {
+				found[channel.Version] = true
+				versions = append(versions, channel.Version)
+			}
+		}
+	}
+	return
+}
+
+// Get all versions, mentioned in the channels file or found in the static files directory
+func getKnownVersions(releases *ReleasesStatusType) (versions []string) {
+	found := make(map[string]bool)
+	for _, version := range getStaticVersions() {
+		found[version] = true
+		versions = append(versions, version)
+	}
+	for _, group := range releases.Groups {
+		for _, channel := range group.Channels {
+			if !found[channel.Version] {
+				found[channel.Version] = true
+				versions = append(versions, channel.Version)
+			}
+		}
+	}
+	return
+}
@@ -3,6 +3,7 @@ module github.com/z9r5/v-router
 go 1.16
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/gorilla/mux v1.8.0
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/sirupsen/logrus v1.8.1